@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
@@ -8,7 +9,10 @@ import (
 
 	"link/internal/config"
 	"link/internal/database"
+	"link/internal/handlers"
+	"link/internal/jobs"
 	"link/internal/routes"
+	"link/internal/store"
 	"link/internal/utils"
 
 	"github.com/labstack/echo/v4"
@@ -26,6 +30,8 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	store.InitInstructionStore(db, cfg.Nodes.InstructionAckTimeout)
+
 	// Initialize experiment environment (creates venv if needed)
 	if err := utils.InitializeExperimentEnvironment(cfg.Server.PythonEnvPath); err != nil {
 		log.Fatalf("Failed to initialize experiment environment: %v", err)
@@ -37,19 +43,54 @@ func main() {
 		log.Fatalf("Failed to get Python environment: %v", err)
 	}
 
+	pythonEnv.ConfigureFederations(cfg.Federation.PortRangeStart, cfg.Federation.MaxConcurrentExperiments)
+	runner, err := utils.NewRunner(cfg.Federation.Runner)
+	if err != nil {
+		log.Fatalf("Failed to configure federation runner: %v", err)
+	}
+	pythonEnv.ConfigureRunner(runner)
+	pythonEnv.ConfigureEnvironments(cfg.Environments.BaseInterpreter)
+	if err := pythonEnv.ConfigureWheelhouse(cfg.Environments.Wheelhouse, cfg.Environments.Offline); err != nil {
+		log.Fatalf("Failed to configure wheelhouse: %v", err)
+	}
+
+	if err := pythonEnv.InstallFlwr(); err != nil {
+		log.Fatalf("Failed to install Flower: %v", err)
+	}
+
+	signer, err := utils.LoadManifestSigner(cfg.Auth.ServerKeyFile)
+	if err != nil {
+		log.Fatalf("Failed to load manifest signing key: %v", err)
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	e := echo.New()
 
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{echo.GET, echo.PUT, echo.POST, echo.DELETE, echo.OPTIONS},
-		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
+		AllowMethods:     []string{echo.GET, echo.PUT, echo.PATCH, echo.POST, echo.DELETE, echo.OPTIONS},
+		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, "Upload-Offset"},
+		ExposeHeaders:    []string{"Upload-Offset"},
 		AllowCredentials: true,
 	}))
 
-	routes.SetupRoutes(e, db, cfg, pythonEnv)
+	jobRunner := jobs.NewRunner(db, cfg.Jobs.PollInterval, cfg.Jobs.RetryDelay)
+	experimentHandler := &handlers.ExperimentHandler{DB: db, Config: cfg, PythonEnv: pythonEnv, Signer: signer, Jobs: jobRunner}
+	experimentHandler.RegisterJobs(jobRunner)
+
+	routes.SetupRoutes(e, db, cfg, pythonEnv, signer, experimentHandler, jobRunner)
+
+	// Background workers, stopped through ctx on shutdown
+	pythonEnv.Events().Subscribe(experimentHandler.HandleFederationEvent)
+	go experimentHandler.MonitorNodeLiveness(ctx)
+	go experimentHandler.DrainQueue()
+	go experimentHandler.ScheduleCleanup(ctx)
+	go jobRunner.Run(ctx, cfg.Jobs.Workers)
 
 	go func() {
 		if err := e.Start(cfg.Server.Port); err != nil {
@@ -59,14 +100,11 @@ func main() {
 
 	<-quit
 	log.Println("Shutting down server...")
+	cancel()
 
 	// Cleanup Python processes
-	if err := pythonEnv.CleanupSuperLink(); err != nil {
-		log.Printf("Error during SuperLink cleanup: %v", err)
-	}
-
-	if err := pythonEnv.CleanupFlwr(); err != nil {
-		log.Printf("Error during Flwr cleanup: %v", err)
+	if err := pythonEnv.StopAllFederations(); err != nil {
+		log.Printf("Error during federation cleanup: %v", err)
 	}
 
 	log.Println("Server stopped")
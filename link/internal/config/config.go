@@ -1,18 +1,25 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Auth     AuthConfig
+	Server       ServerConfig
+	Database     DatabaseConfig
+	Auth         AuthConfig
+	Nodes        NodesConfig
+	Federation   FederationConfig
+	Uploads      UploadsConfig
+	Environments EnvironmentsConfig
+	Jobs         JobsConfig
 }
 
 type ServerConfig struct {
-	Port            string
-	PythonEnvPath   string
+	Port          string
+	PythonEnvPath string
 }
 
 type DatabaseConfig struct {
@@ -25,7 +32,51 @@ type DatabaseConfig struct {
 }
 
 type AuthConfig struct {
-	SecretKey       string
+	SecretKey     string
+	ServerKeyFile string
+}
+
+type NodesConfig struct {
+	InstructionAckTimeout time.Duration
+	LongPollMaxWait       time.Duration
+	HeartbeatInterval     time.Duration
+	OfflineAfter          time.Duration
+	LivenessCheckInterval time.Duration
+	MinActiveNodes        int
+}
+
+type FederationConfig struct {
+	PortRangeStart           int
+	MaxConcurrentExperiments int
+	PublicHost               string
+	Runner                   string
+	ReadinessTimeout         time.Duration
+}
+
+// UploadsConfig limits the size of uploaded experiment archives and what they
+// may expand to
+type UploadsConfig struct {
+	MaxArchiveFiles      int
+	MaxExtractedSize     int64
+	MaxExtractedFileSize int64
+	MaxUploadSize        int64
+}
+
+// EnvironmentsConfig controls the isolated Python environments experiments
+// run in
+type EnvironmentsConfig struct {
+	BaseInterpreter string
+	Retention       time.Duration
+	GCInterval      time.Duration
+	Wheelhouse      string
+	Offline         bool
+}
+
+// JobsConfig controls the workers running background jobs
+type JobsConfig struct {
+	Workers      int
+	PollInterval time.Duration
+	RetryDelay   time.Duration
 }
 
 func Load() (*Config, error) {
@@ -35,6 +86,30 @@ func Load() (*Config, error) {
 	viper.AddConfigPath("./config")
 	viper.AutomaticEnv()
 
+	viper.SetDefault("auth.serverKeyFile", "authentication/keys/server_credentials")
+	viper.SetDefault("nodes.instructionAckTimeout", 2*time.Minute)
+	viper.SetDefault("nodes.longPollMaxWait", 60*time.Second)
+	viper.SetDefault("nodes.heartbeatInterval", 15*time.Second)
+	viper.SetDefault("nodes.offlineAfter", 2*time.Minute)
+	viper.SetDefault("nodes.livenessCheckInterval", 30*time.Second)
+	viper.SetDefault("nodes.minActiveNodes", 1)
+	viper.SetDefault("federation.portRangeStart", 9100)
+	viper.SetDefault("federation.maxConcurrentExperiments", 4)
+	viper.SetDefault("federation.runner", "local")
+	viper.SetDefault("federation.readinessTimeout", 30*time.Second)
+	viper.SetDefault("uploads.maxArchiveFiles", 1000)
+	viper.SetDefault("uploads.maxExtractedSize", 2<<30)
+	viper.SetDefault("uploads.maxExtractedFileSize", 1<<30)
+	viper.SetDefault("uploads.maxUploadSize", 4<<30)
+	viper.SetDefault("environments.baseInterpreter", "python3")
+	viper.SetDefault("environments.retention", 7*24*time.Hour)
+	viper.SetDefault("environments.gcInterval", time.Hour)
+	viper.SetDefault("environments.wheelhouse", "wheelhouse")
+	viper.SetDefault("environments.offline", false)
+	viper.SetDefault("jobs.workers", 4)
+	viper.SetDefault("jobs.pollInterval", 2*time.Second)
+	viper.SetDefault("jobs.retryDelay", 30*time.Second)
+
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, err
 	}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"fmt"
+	"log"
 
 	"link/internal/config"
 	"link/internal/models"
@@ -20,7 +21,17 @@ func Init(cfg config.DatabaseConfig) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.Node{}, &models.Metadata{}, &models.Experiment{}, &models.ExperimentNode{})
+	err = renameRunColumns(db)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.AutoMigrate(&models.User{}, &models.Node{}, &models.Metadata{}, &models.Experiment{}, &models.ExperimentNode{}, &models.Instruction{}, &models.ExperimentQueueEntry{}, &models.ExperimentStatusTransition{}, &models.Run{}, &models.RunNode{}, &models.ExperimentRevision{}, &models.Upload{}, &models.Job{}, &models.JobLog{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = dropExperimentRunColumns(db)
 	if err != nil {
 		return nil, err
 	}
@@ -30,9 +41,40 @@ func Init(cfg config.DatabaseConfig) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	err = migrateExperimentStatuses(db)
+	if err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
+// renameRunColumns renames the metrics column of runs to summary before
+// AutoMigrate would add summary next to it. The column keeps the raw summary
+// section of the flwr log rather than parsed metrics.
+func renameRunColumns(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.Run{}) || !migrator.HasColumn(&models.Run{}, "metrics") || migrator.HasColumn(&models.Run{}, "summary") {
+		return nil
+	}
+	return migrator.RenameColumn(&models.Run{}, "metrics", "summary")
+}
+
+// dropExperimentRunColumns drops the per-attempt columns experiments had
+// before runs kept them. AutoMigrate never drops columns on its own.
+func dropExperimentRunColumns(db *gorm.DB) error {
+	migrator := db.Migrator()
+	for _, column := range []string{"started_at", "finished_at", "exit_code"} {
+		if !migrator.HasColumn(&models.Experiment{}, column) {
+			continue
+		}
+		if err := migrator.DropColumn(&models.Experiment{}, column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func createDefaultUser(db *gorm.DB) error {
 	var count int64
 	db.Model(&models.User{}).Count(&count)
@@ -46,12 +88,76 @@ func createDefaultUser(db *gorm.DB) error {
 			Username: "admin",
 			Password: string(hashedPassword),
 			Approved: true,
+			Admin:    true,
 		}
 
 		result := db.Create(&defaultUser)
 		if result.Error != nil {
 			return result.Error
 		}
+		return nil
 	}
+
+	// Databases created before users had roles keep the default account as admin
+	var admins int64
+	db.Model(&models.User{}).Where("admin = ?", true).Count(&admins)
+	if admins == 0 {
+		return db.Model(&models.User{}).Where("username = ?", "admin").Update("admin", true).Error
+	}
+	return nil
+}
+
+// migrateExperimentStatuses moves experiments whose status predates the typed
+// statuses, such as PENDING or ACCEPTED, to the status their nodes imply:
+// READY once a node accepted, AWAITING_NODES while nodes were selected and
+// DRAFT otherwise. Each change is recorded in the status history.
+func migrateExperimentStatuses(db *gorm.DB) error {
+	var experiments []models.Experiment
+	if err := db.Select("id", "status").Find(&experiments).Error; err != nil {
+		return err
+	}
+
+	for _, experiment := range experiments {
+		if experiment.Status.IsKnown() {
+			continue
+		}
+
+		var accepted, selected int64
+		if err := db.Model(&models.ExperimentNode{}).
+			Where("experiment_id = ? AND status = ?", experiment.ID, models.ExperimentNodeStatusAccepted).
+			Count(&accepted).Error; err != nil {
+			return err
+		}
+		if err := db.Model(&models.ExperimentNode{}).
+			Where("experiment_id = ?", experiment.ID).
+			Count(&selected).Error; err != nil {
+			return err
+		}
+
+		status := models.ExperimentStatusDraft
+		if accepted > 0 {
+			status = models.ExperimentStatusReady
+		} else if selected > 0 {
+			status = models.ExperimentStatusAwaitingNodes
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.Experiment{}).Where("id = ?", experiment.ID).Update("status", status).Error; err != nil {
+				return err
+			}
+			return tx.Create(&models.ExperimentStatusTransition{
+				ExperimentID: experiment.ID,
+				FromStatus:   experiment.Status,
+				ToStatus:     status,
+				Actor:        "system",
+				Reason:       fmt.Sprintf("migrated legacy status %q", experiment.Status),
+			}).Error
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Migrated experiment %d from legacy status %q to %s", experiment.ID, experiment.Status, status)
+	}
+
 	return nil
-}
\ No newline at end of file
+}
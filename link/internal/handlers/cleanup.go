@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"link/internal/jobs"
+	"link/internal/models"
+)
+
+// stagingMaxAge is the age after which a staging directory is considered
+// abandoned by an interrupted upload
+const stagingMaxAge = 24 * time.Hour
+
+// ScheduleCleanup periodically queues a cleanup job. It returns when the
+// context is cancelled.
+func (h *ExperimentHandler) ScheduleCleanup(ctx context.Context) {
+	ticker := time.NewTicker(h.Config.Environments.GCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := h.Jobs.Enqueue(h.DB, JobCleanup, struct{}{}, jobs.Options{Unique: true}); err != nil {
+				log.Printf("Failed to queue cleanup: %v", err)
+			}
+		}
+	}
+}
+
+// cleanupJob removes Python environments that neither an active run nor the
+// current revision of an experiment uses and that have not been used within
+// the configured retention, as well as abandoned staging directories
+func (h *ExperimentHandler) cleanupJob(ctx context.Context, job *models.Job, logger *jobs.Logger) error {
+	removed, err := h.collectPythonEnvs()
+	if err != nil {
+		return fmt.Errorf("failed to collect Python environments: %w", err)
+	}
+	for _, key := range removed {
+		logger.Printf("Removed unused Python environment %s", key)
+	}
+
+	stagingRoot := filepath.Join("uploads", ".staging")
+	entries, err := os.ReadDir(stagingRoot)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read staging directory: %w", err)
+	}
+	cutoff := time.Now().Add(-stagingMaxAge)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(stagingRoot, entry.Name())); err != nil {
+			logger.Printf("Failed to remove staging directory %s: %v", entry.Name(), err)
+			continue
+		}
+		logger.Printf("Removed abandoned staging directory %s", entry.Name())
+	}
+
+	return nil
+}
+
+func (h *ExperimentHandler) collectPythonEnvs() ([]string, error) {
+	inUse := make(map[string]bool)
+
+	var activeEnvs []string
+	if err := h.DB.Model(&models.Run{}).
+		Where("status IN ? AND python_env <> ''", models.ActiveExperimentStatuses).
+		Pluck("python_env", &activeEnvs).Error; err != nil {
+		return nil, err
+	}
+	for _, key := range activeEnvs {
+		inUse[key] = true
+	}
+
+	// The next start of an experiment reuses the environment of its current revision
+	var experiments []models.Experiment
+	if err := h.DB.Select("id", "base_path").Where("base_path <> ''").Find(&experiments).Error; err != nil {
+		return nil, err
+	}
+	for _, experiment := range experiments {
+		key, err := h.PythonEnv.ExperimentEnvKey(experiment.BasePath)
+		if err != nil {
+			continue
+		}
+		inUse[key] = true
+	}
+
+	return h.PythonEnv.CollectExperimentEnvs(inUse, h.Config.Environments.Retention)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"link/internal/models"
+	"link/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// authorizeExperimentAccess loads an experiment the caller may read. Nodes
+// must have been selected for the experiment, users must be approved. IDs
+// taken from the request go through parseExperimentID first, as gorm would
+// treat any other string as SQL.
+func authorizeExperimentAccess(c echo.Context, db *gorm.DB, experimentID uint) (*models.Experiment, error) {
+	var experiment models.Experiment
+	if err := db.First(&experiment, experimentID).Error; err != nil {
+		return nil, utils.NewNotFoundError("Experiment not found")
+	}
+
+	if node, ok := c.Get("node").(models.Node); ok {
+		var count int64
+		if err := db.Model(&models.ExperimentNode{}).
+			Where("experiment_id = ? AND node_id = ?", experiment.ID, node.ID).
+			Count(&count).Error; err != nil {
+			return nil, utils.NewInternalServerError("Failed to check experiment access")
+		}
+		if count == 0 {
+			// Do not reveal experiments the node is not part of
+			return nil, utils.NewNotFoundError("Experiment not found")
+		}
+		return &experiment, nil
+	}
+
+	userID, ok := c.Get("user_id").(float64)
+	if !ok {
+		return nil, utils.NewUnauthorizedError("Authentication failed")
+	}
+	var user models.User
+	if err := db.First(&user, uint(userID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.NewUnauthorizedError("Authentication failed")
+		}
+		return nil, utils.NewInternalServerError("Failed to check experiment access")
+	}
+	if !user.Approved {
+		return nil, utils.NewUnauthorizedError("User not approved")
+	}
+
+	return &experiment, nil
+}
+
+// parseExperimentID parses an experiment ID taken from the request
+func parseExperimentID(value string) (uint, error) {
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || id == 0 {
+		return 0, utils.NewBadRequestError("Invalid experiment ID")
+	}
+	return uint(id), nil
+}
+
+// requestUser loads the approved user making the request. Nodes are rejected.
+func requestUser(c echo.Context, db *gorm.DB) (*models.User, error) {
+	userID, ok := c.Get("user_id").(float64)
+	if !ok {
+		return nil, utils.NewForbiddenError("Only users can do this")
+	}
+
+	var user models.User
+	if err := db.First(&user, uint(userID)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.NewUnauthorizedError("Authentication failed")
+		}
+		return nil, utils.NewInternalServerError("Failed to fetch user")
+	}
+	if !user.Approved {
+		return nil, utils.NewUnauthorizedError("User not approved")
+	}
+
+	return &user, nil
+}
@@ -4,14 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"link/internal/config"
+	"link/internal/jobs"
 	"link/internal/models"
 	"link/internal/store"
 	"link/internal/utils"
 
-	"crypto/sha256"
 	"io"
 	"log"
+	"mime/multipart"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -26,6 +29,8 @@ type ExperimentHandler struct {
 	DB        *gorm.DB
 	Config    *config.Config
 	PythonEnv *utils.PythonEnv
+	Signer    *utils.ManifestSigner
+	Jobs      *jobs.Runner
 }
 
 func (h *ExperimentHandler) CreateExperiment(c echo.Context) error {
@@ -34,102 +39,204 @@ func (h *ExperimentHandler) CreateExperiment(c echo.Context) error {
 		return utils.NewBadRequestError("Failed to parse form data")
 	}
 
-	experiment := new(models.Experiment)
-	experiment.Name = c.FormValue("name")
-	experiment.Description = c.FormValue("description")
-	experiment.Status = c.FormValue("status")
-	userID := uint(c.Get("user_id").(float64))
-	experiment.UserID = userID
+	selectedNodes, err := parseSelectedNodes(c)
+	if err != nil {
+		return err
+	}
 
-	if err := h.DB.Create(experiment).Error; err != nil {
-		log.Printf("Error creating experiment: %v\n", err)
-		return utils.NewInternalServerError("Failed to create experiment")
+	// Completed chunked uploads may be large, so they are extracted by a job
+	if c.FormValue("upload_id") != "" {
+		return h.createExperimentFromUpload(c, selectedNodes)
 	}
 
-	if err := h.handleFileUploads(c, experiment); err != nil {
+	archive, err := openExperimentArchive(c, h.DB)
+	if err != nil {
 		return err
 	}
+	defer archive.file.Close()
+
+	// Files are checked in a staging directory and only moved below uploads/<id>
+	// as the first revision once the experiment rows are about to be committed
+	stagingDir, appFolder, err := h.stageExperimentPackage(archive, c.FormValue("normalize") == "true")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	experiment := newDraftExperiment(c)
+
+	var revision *models.ExperimentRevision
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := createDraftExperiment(tx, experiment, userActor(c)); err != nil {
+			return err
+		}
+
+		var err error
+		revision, err = h.createRevision(tx, experiment, stagingDir, appFolder, userActor(c))
+		if err != nil {
+			return err
+		}
+
+		if err := createExperimentNodes(tx, experiment, selectedNodes); err != nil {
+			return err
+		}
+
+		if err := h.queueDependencyInstall(tx, revision); err != nil {
+			return err
+		}
 
-	if err := h.createExperimentNodes(c, experiment); err != nil {
+		return transitionExperiment(tx, experiment, models.ExperimentStatusAwaitingNodes, userActor(c), "experiment sent to the selected nodes")
+	})
+	if err != nil {
+		// The files may already have been moved into place
+		discardRevision(revision)
 		return err
 	}
 
+	// Nodes are only told about the experiment once it exists for them to fetch
+	if err := store.GlobalInstructionStore.AddInstructions(newExperimentInstructions(experiment, revision, selectedNodes)); err != nil {
+		log.Printf("Error queueing instructions: %v\n", err)
+		return utils.NewInternalServerError("Experiment created but failed to queue instructions for nodes")
+	}
+
 	return c.JSON(201, experiment)
 }
 
-func (h *ExperimentHandler) handleFileUploads(c echo.Context, experiment *models.Experiment) error {
-	zipFile, err := c.FormFile("experimentFiles")
-	if err != nil {
-		return utils.NewBadRequestError("Failed to get experiment files")
+func newDraftExperiment(c echo.Context) *models.Experiment {
+	return &models.Experiment{
+		Name:        c.FormValue("name"),
+		Description: c.FormValue("description"),
+		Status:      models.ExperimentStatusDraft,
+		UserID:      uint(c.Get("user_id").(float64)),
 	}
+}
 
-	// Create experiment directory
-	experimentDir := filepath.Join("uploads", fmt.Sprintf("%d", experiment.ID))
-	if err := os.MkdirAll(experimentDir, 0755); err != nil {
-		return utils.NewInternalServerError("Failed to create experiment directory")
+func createDraftExperiment(tx *gorm.DB, experiment *models.Experiment, actor string) error {
+	if err := tx.Create(experiment).Error; err != nil {
+		log.Printf("Error creating experiment: %v\n", err)
+		return utils.NewInternalServerError("Failed to create experiment")
 	}
 
-	// Extract zip contents
-	if err := utils.ExtractZipFile(zipFile, experimentDir); err != nil {
-		return utils.NewInternalServerError(fmt.Sprintf("Failed to extract zip file: %v", err))
+	if err := tx.Create(&models.ExperimentStatusTransition{
+		ExperimentID: experiment.ID,
+		ToStatus:     models.ExperimentStatusDraft,
+		Actor:        actor,
+		Reason:       "experiment created",
+	}).Error; err != nil {
+		return utils.NewInternalServerError("Failed to record experiment status")
 	}
 
-	// Find the experiment name folder
-	entries, err := os.ReadDir(experimentDir)
+	return nil
+}
+
+// createExperimentFromUpload creates the experiment as a draft and queues the
+// job extracting its completed chunked upload. The job creates the first
+// revision and sends the experiment to the selected nodes.
+func (h *ExperimentHandler) createExperimentFromUpload(c echo.Context, selectedNodes []selectedNode) error {
+	upload, err := findCompletedUpload(c, h.DB)
 	if err != nil {
-		return utils.NewInternalServerError("Failed to read experiment directory")
+		return err
 	}
 
-	var experimentNameDir string
-	for _, entry := range entries {
-		if entry.IsDir() {
-			experimentNameDir = entry.Name()
-			break
+	experiment := newDraftExperiment(c)
+	var job *models.Job
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := createDraftExperiment(tx, experiment, userActor(c)); err != nil {
+			return err
 		}
+
+		var err error
+		job, err = h.Jobs.Enqueue(tx, JobExtractArchive, extractArchivePayload{
+			UploadID:      upload.ID,
+			SelectedNodes: selectedNodes,
+			Normalize:     c.FormValue("normalize") == "true",
+			Actor:         userActor(c),
+		}, jobs.Options{ExperimentID: &experiment.ID})
+		if err != nil {
+			return utils.NewInternalServerError("Failed to queue the archive extraction")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(202, map[string]interface{}{
+		"experiment": experiment,
+		"job_id":     job.ID,
+	})
+}
+
+// stageExperimentPackage extracts and checks an uploaded package in a new
+// directory below uploads/.staging and returns it with the app folder name
+func (h *ExperimentHandler) stageExperimentPackage(archive *experimentArchive, normalize bool) (string, string, error) {
+	stagingDir, err := newStagingDir()
+	if err != nil {
+		return "", "", utils.NewInternalServerError("Failed to create staging directory")
 	}
 
-	if experimentNameDir == "" {
-		return utils.NewBadRequestError("Invalid zip structure: missing experiment folder")
+	appFolder, err := h.preparePackage(archive, stagingDir, normalize)
+	if err != nil {
+		os.RemoveAll(stagingDir)
+		return "", "", err
 	}
 
-	// Verify the inner folder structure
-	innerPath := filepath.Join(experimentDir, experimentNameDir, experimentNameDir)
-	if _, err := os.Stat(innerPath); os.IsNotExist(err) {
-		return utils.NewBadRequestError("Invalid zip structure: missing inner folder")
+	return stagingDir, appFolder, nil
+}
+
+// preparePackage extracts the archive into dir, checks it and optionally
+// normalizes its pyproject.toml. It returns the name of the app folder.
+func (h *ExperimentHandler) preparePackage(archive *experimentArchive, dir string, normalize bool) (string, error) {
+	if err := archive.extract(dir, h.archiveLimits()); err != nil {
+		if utils.IsArchiveError(err) {
+			return "", utils.NewBadRequestError(fmt.Sprintf("Invalid experiment archive: %v", err))
+		}
+		return "", utils.NewInternalServerError(fmt.Sprintf("Failed to extract archive: %v", err))
 	}
 
-	// Verify required files exist
-	requiredFiles := []string{
-		filepath.Join(experimentDir, experimentNameDir, "pyproject.toml"),
-		filepath.Join(experimentDir, experimentNameDir, experimentNameDir, "client_app.py"),
-		filepath.Join(experimentDir, experimentNameDir, experimentNameDir, "server_app.py"),
+	report := inspectPackage(dir)
+	if len(report.Problems) > 0 {
+		return "", utils.NewValidationError("Invalid experiment package", report.Problems)
 	}
 
-	for _, file := range requiredFiles {
-		if _, err := os.Stat(file); os.IsNotExist(err) {
-			return utils.NewBadRequestError(fmt.Sprintf("Missing required file: %s", filepath.Base(file)))
+	if normalize {
+		appDir := filepath.Join(dir, report.AppFolder)
+		if _, err := h.PythonEnv.NormalizePyProject(appDir, report.AppFolder); err != nil {
+			return "", utils.NewInternalServerError(fmt.Sprintf("Failed to normalize pyproject.toml: %v", err))
 		}
 	}
 
-	// Update experiment with base path
-	experiment.BasePath = experimentDir + "/" + experimentNameDir
-	if err := h.DB.Save(experiment).Error; err != nil {
-		return utils.NewInternalServerError("Failed to update experiment with file path")
+	return report.AppFolder, nil
+}
+
+func (h *ExperimentHandler) archiveLimits() utils.ArchiveLimits {
+	return utils.ArchiveLimits{
+		MaxFiles:     h.Config.Uploads.MaxArchiveFiles,
+		MaxTotalSize: h.Config.Uploads.MaxExtractedSize,
+		MaxFileSize:  h.Config.Uploads.MaxExtractedFileSize,
 	}
+}
 
-	return nil
+// selectedNode is a node and one of its datasets chosen for an experiment
+type selectedNode struct {
+	ID         uint `json:"id"`
+	NodeID     uint `json:"node_id"`
+	MetadataID uint `json:"metadata_id"`
 }
 
-func (h *ExperimentHandler) createExperimentNodes(c echo.Context, experiment *models.Experiment) error {
+func parseSelectedNodes(c echo.Context) ([]selectedNode, error) {
 	selectedNodesJSON := c.FormValue("selectedNodes")
-	var selectedNodes []struct {
-		ID         uint `json:"id"`
-		NodeID     uint `json:"node_id"`
-		MetadataID uint `json:"metadata_id"`
-	}
+	var selectedNodes []selectedNode
 	if err := json.Unmarshal([]byte(selectedNodesJSON), &selectedNodes); err != nil {
 		log.Printf("Error unmarshalling selectedNodes: %v\n", err)
-		return utils.NewBadRequestError("Invalid node selection data")
+		return nil, utils.NewBadRequestError("Invalid node selection data")
+	}
+	return selectedNodes, nil
+}
+
+func createExperimentNodes(tx *gorm.DB, experiment *models.Experiment, selectedNodes []selectedNode) error {
+	if len(selectedNodes) == 0 {
+		return nil
 	}
 
 	experimentNodes := make([]models.ExperimentNode, len(selectedNodes))
@@ -142,11 +249,15 @@ func (h *ExperimentHandler) createExperimentNodes(c echo.Context, experiment *mo
 		}
 	}
 
-	if err := h.DB.Create(&experimentNodes).Error; err != nil {
+	if err := tx.Create(&experimentNodes).Error; err != nil {
 		log.Printf("Error creating experiment nodes: %v\n", err)
 		return utils.NewInternalServerError("Failed to create experiment nodes")
 	}
 
+	return nil
+}
+
+func newExperimentInstructions(experiment *models.Experiment, revision *models.ExperimentRevision, selectedNodes []selectedNode) []store.NodeInstruction {
 	instructions := make([]store.NodeInstruction, len(selectedNodes))
 	for i, trio := range selectedNodes {
 		instructions[i] = store.NodeInstruction{
@@ -158,14 +269,15 @@ func (h *ExperimentHandler) createExperimentNodes(c echo.Context, experiment *mo
 					"name":          experiment.Name,
 					"description":   experiment.Description,
 					"files_path":    experiment.BasePath,
+					"revision":      revision.Number,
+					"manifest_hash": revision.ManifestHash,
+					"signature":     revision.Signature,
 					"metadata_id":   trio.MetadataID,
 				},
 			},
 		}
 	}
-	store.GlobalInstructionStore.AddInstructions(instructions)
-
-	return nil
+	return instructions
 }
 
 func (h *ExperimentHandler) AcceptExperiment(c echo.Context) error {
@@ -185,14 +297,72 @@ func (h *ExperimentHandler) updateExperimentNodeStatus(c echo.Context, status mo
 		return utils.NewNotFoundError("Experiment node not found")
 	}
 
-	experimentNode.Status = status
-	if err := h.DB.Save(&experimentNode).Error; err != nil {
-		return utils.NewInternalServerError("Failed to update experiment node status")
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		experimentNode.Status = status
+		if err := tx.Save(&experimentNode).Error; err != nil {
+			return utils.NewInternalServerError("Failed to update experiment node status")
+		}
+
+		var experiment models.Experiment
+		if err := tx.First(&experiment, experimentNode.ExperimentID).Error; err != nil {
+			return utils.NewNotFoundError("Experiment not found")
+		}
+
+		return syncNodeReadiness(tx, &experiment, nodeActor(node.ID))
+	})
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(200, experimentNode)
 }
 
+func (h *ExperimentHandler) GetExperimentHistory(c echo.Context) error {
+	experimentID, err := parseExperimentID(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	experiment, err := authorizeExperimentAccess(c, h.DB, experimentID)
+	if err != nil {
+		return err
+	}
+
+	var history []models.ExperimentStatusTransition
+	if err := h.DB.Where("experiment_id = ?", experiment.ID).Order("id").Find(&history).Error; err != nil {
+		return utils.NewInternalServerError("Failed to fetch experiment history")
+	}
+
+	return c.JSON(200, history)
+}
+
+func (h *ExperimentHandler) ListRuns(c echo.Context) error {
+	experimentID := c.Param("id")
+
+	var runs []models.Run
+	if err := h.DB.Preload("Nodes").
+		Where("experiment_id = ?", experimentID).
+		Order("number DESC").
+		Find(&runs).Error; err != nil {
+		return utils.NewInternalServerError("Failed to fetch runs")
+	}
+
+	return c.JSON(200, runs)
+}
+
+func (h *ExperimentHandler) GetRun(c echo.Context) error {
+	var run models.Run
+	if err := h.DB.Preload("Nodes.Node", func(db *gorm.DB) *gorm.DB {
+		return db.Omit("Password", "PublicKey")
+	}).
+		Preload("Nodes.Metadata").
+		Where("experiment_id = ? AND id = ?", c.Param("id"), c.Param("runID")).
+		First(&run).Error; err != nil {
+		return utils.NewNotFoundError("Run not found")
+	}
+
+	return c.JSON(200, run)
+}
+
 func (h *ExperimentHandler) ListExperiments(c echo.Context) error {
 	var experiments []models.Experiment
 	if err := h.DB.Preload("ExperimentNodes.Metadata").
@@ -209,26 +379,63 @@ func (h *ExperimentHandler) ListExperiments(c echo.Context) error {
 	return c.JSON(200, experiments)
 }
 
+// StartTraining starts an experiment right away when a federation slot is free
+// and no other start request is waiting, otherwise the request is queued
 func (h *ExperimentHandler) StartTraining(c echo.Context) error {
 	experimentMutex.Lock()
 	defer experimentMutex.Unlock()
 
-	return h.DB.Transaction(func(tx *gorm.DB) error {
-		// Check if there is already an experiment in preparing or training state
-		var activeCount int64
-		if err := tx.Model(&models.Experiment{}).
-			Where("status IN (?)", []string{string(models.ExperimentNodeStatusPreparing), string(models.ExperimentNodeStatusTraining)}).
-			Count(&activeCount).Error; err != nil {
-			return utils.NewInternalServerError("Failed to check active experiments")
+	experimentID, err := parseExperimentID(c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	var request struct {
+		Priority  int                    `json:"priority"`
+		RunConfig map[string]interface{} `json:"run_config"`
+	}
+	if c.Request().ContentLength > 0 {
+		if err := c.Bind(&request); err != nil {
+			return utils.NewBadRequestError("Invalid request payload")
 		}
+	}
+
+	if err := checkQueuePriority(c, h.DB, request.Priority); err != nil {
+		return err
+	}
 
-		if activeCount > 0 {
-			return utils.NewBadRequestError("Another experiment is already in progress")
+	var queued int64
+	if err := h.DB.Model(&models.ExperimentQueueEntry{}).
+		Where("status = ?", models.QueueEntryStatusQueued).
+		Count(&queued).Error; err != nil {
+		return utils.NewInternalServerError("Failed to check experiment queue")
+	}
+
+	if queued > 0 || !h.PythonEnv.HasFreeFederationSlot() {
+		user, err := requestUser(c, h.DB)
+		if err != nil {
+			return err
 		}
+		return h.enqueueExperiment(c, experimentID, user.ID, request.Priority, request.RunConfig)
+	}
 
-		experimentID := c.Param("id")
+	experiment, err := h.startExperiment(experimentID, request.RunConfig, userActor(c), "started by user")
+	if err != nil {
+		return err
+	}
 
-		var experiment models.Experiment
+	return c.JSON(200, experiment)
+}
+
+// startExperiment moves the accepted nodes of an experiment to PREPARING,
+// reserves its federation and queues the job that starts its SuperLink and
+// sends START_TRAINING to the nodes. The run config overrides are checked
+// against the experiment's pyproject.toml. The caller must hold experimentMutex.
+func (h *ExperimentHandler) startExperiment(experimentID uint, runConfig map[string]interface{}, actor, reason string) (*models.Experiment, error) {
+	var experiment models.Experiment
+	var federation *utils.Federation
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Preload("User", func(db *gorm.DB) *gorm.DB {
 			return db.Omit("Password")
 		}).
@@ -239,9 +446,32 @@ func (h *ExperimentHandler) StartTraining(c echo.Context) error {
 			return utils.NewNotFoundError("Experiment not found")
 		}
 
-		// Update experiment nodes status and create instructions only for accepted nodes
+		if experiment.Status.IsActive() {
+			return utils.NewBadRequestError("Experiment is already in progress")
+		}
+
+		if !experiment.Status.CanTransitionTo(models.ExperimentStatusPreparing) {
+			return utils.NewBadRequestError(fmt.Sprintf("Experiment cannot be started while it is %s", experiment.Status))
+		}
+
+		overrides, effective, err := resolveRunConfig(&experiment, runConfig)
+		if err != nil {
+			return err
+		}
+
+		// Every running experiment holds its own SuperLink and ports
+		if !h.PythonEnv.HasFreeFederationSlot() {
+			return utils.NewBadRequestError("The maximum number of concurrent experiments is already in progress")
+		}
+
+		// Nodes that accepted the current files take part, including those
+		// that already trained it in an earlier run
 		var experimentNodes []models.ExperimentNode
-		if err := tx.Where("experiment_id = ? AND status = ?", experiment.ID, models.ExperimentNodeStatusAccepted).Find(&experimentNodes).Error; err != nil {
+		if err := tx.Where("experiment_id = ? AND status IN ?", experiment.ID, []models.ExperimentNodeStatus{
+			models.ExperimentNodeStatusAccepted,
+			models.ExperimentNodeStatusCompleted,
+			models.ExperimentNodeStatusStopped,
+		}).Find(&experimentNodes).Error; err != nil {
 			return utils.NewInternalServerError("Failed to fetch experiment nodes")
 		}
 
@@ -249,61 +479,135 @@ func (h *ExperimentHandler) StartTraining(c echo.Context) error {
 			return utils.NewBadRequestError("No nodes have accepted this experiment")
 		}
 
-		instructions := make([]store.NodeInstruction, len(experimentNodes))
-		for i, en := range experimentNodes {
+		run, err := h.createRun(tx, &experiment, experimentNodes, overrides, effective)
+		if err != nil {
+			log.Printf("Error creating run for experiment %d: %v", experiment.ID, err)
+			return utils.NewInternalServerError("Failed to create run")
+		}
+
+		for _, en := range experimentNodes {
 			en.Status = models.ExperimentNodeStatusPreparing
 			if err := tx.Save(&en).Error; err != nil {
 				return utils.NewInternalServerError("Failed to update experiment node status")
 			}
-
-			instructions[i] = store.NodeInstruction{
-				NodeID: en.NodeID,
-				Instruction: models.Instruction{
-					Type:    models.InstructionStartTraining,
-					Payload: map[string]interface{}{"experiment_id": experiment.ID},
-				},
-			}
 		}
 
-		experiment.Status = string(models.ExperimentNodeStatusPreparing)
-		if err := tx.Save(&experiment).Error; err != nil {
-			return utils.NewInternalServerError("Failed to update experiment status")
+		if err := transitionExperiment(tx, &experiment, models.ExperimentStatusPreparing, actor, reason); err != nil {
+			return err
 		}
 
-		if err := h.writeNodeKeysToCSV(experiment.ID); err != nil {
-			return utils.NewInternalServerError(fmt.Sprintf("Failed to write node keys to CSV: %v", err))
+		// The slot is taken right away so further starts see it in use, while
+		// installing the environment and starting the SuperLink run as a job
+		federation, err = h.PythonEnv.ReserveFederation(fmt.Sprintf("%d", experiment.ID), run.LogDir)
+		if err != nil {
+			return utils.NewBadRequestError(fmt.Sprintf("Failed to reserve a federation: %v", err))
 		}
 
-		if err := h.PythonEnv.InitializeSuperLink(); err != nil {
-			log.Fatalf("Failed to initialize SuperLink: %v", err)
+		job, err := h.Jobs.Enqueue(tx, JobStartSuperLink, runJobPayload{RunID: run.ID}, jobs.Options{ExperimentID: &experiment.ID})
+		if err != nil {
+			return utils.NewInternalServerError("Failed to queue the SuperLink start")
 		}
 
-		store.GlobalInstructionStore.AddInstructions(instructions)
+		run.StartJobID = &job.ID
+		if err := tx.Model(run).Update("start_job_id", run.StartJobID).Error; err != nil {
+			return utils.NewInternalServerError("Failed to update run")
+		}
+		experiment.Runs = []models.Run{*run}
 
-		return c.JSON(200, experiment)
+		return nil
 	})
+	if err != nil {
+		if federation != nil {
+			h.stopServerProcess(fmt.Sprintf("%d", experiment.ID))
+		}
+		return nil, err
+	}
+
+	return &experiment, nil
 }
 
-func (h *ExperimentHandler) writeNodeKeysToCSV(experimentID uint) error {
-	csvFilePath := "authentication/keys/client_public_keys.csv"
+// createRun records a new numbered run of the experiment with the nodes taking part in it
+func (h *ExperimentHandler) createRun(tx *gorm.DB, experiment *models.Experiment, experimentNodes []models.ExperimentNode, overrides, effective map[string]interface{}) (*models.Run, error) {
+	var lastNumber int
+	if err := tx.Model(&models.Run{}).
+		Where("experiment_id = ?", experiment.ID).
+		Select("COALESCE(MAX(number), 0)").
+		Scan(&lastNumber).Error; err != nil {
+		return nil, err
+	}
+
+	run := models.Run{
+		ExperimentID:       experiment.ID,
+		Number:             lastNumber + 1,
+		Status:             models.ExperimentStatusPreparing,
+		Revision:           experiment.Revision,
+		RunConfig:          effective,
+		RunConfigOverrides: overrides,
+		LogDir:             filepath.Join("uploads", fmt.Sprintf("%d", experiment.ID), "logs", fmt.Sprintf("run_%d", lastNumber+1)),
+	}
+	for _, en := range experimentNodes {
+		run.Nodes = append(run.Nodes, models.RunNode{
+			NodeID:     en.NodeID,
+			MetadataID: en.MetadataID,
+			Status:     models.ExperimentNodeStatusPreparing,
+		})
+	}
+
+	if err := tx.Create(&run).Error; err != nil {
+		return nil, err
+	}
 
+	return &run, nil
+}
+
+// resolveRunConfig validates run config overrides against the
+// [tool.flwr.app.config] table of the experiment and returns the typed
+// overrides with the effective run config
+func resolveRunConfig(experiment *models.Experiment, runConfig map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	pyproject, err := utils.LoadPyProject(filepath.Join(experiment.BasePath, "pyproject.toml"))
+	if err != nil {
+		return nil, nil, utils.NewBadRequestError(fmt.Sprintf("Failed to read the experiment run config: %v", err))
+	}
+
+	overrides, effective, err := pyproject.ResolveRunConfig(runConfig)
+	if err != nil {
+		return nil, nil, utils.NewBadRequestError(fmt.Sprintf("Invalid run config: %v", err))
+	}
+
+	return overrides, effective, nil
+}
+
+func (h *ExperimentHandler) startTrainingPayload(experimentID uint, federation *utils.Federation) map[string]interface{} {
+	payload := map[string]interface{}{
+		"experiment_id":  experimentID,
+		"fleet_api_port": federation.FleetPort,
+	}
+	if h.Config.Federation.PublicHost != "" {
+		payload["fleet_api_address"] = fmt.Sprintf("%s:%d", h.Config.Federation.PublicHost, federation.FleetPort)
+	}
+	return payload
+}
+
+// writeNodeKeysToCSV writes the public keys of the nodes preparing to train in
+// an experiment to the SuperLink's list of authorized keys
+func (h *ExperimentHandler) writeNodeKeysToCSV(tx *gorm.DB, experimentID uint, csvFilePath string) error {
 	var experimentNodes []models.ExperimentNode
-	if err := h.DB.Preload("Node", func(db *gorm.DB) *gorm.DB {
-			return db.Select("id", "public_key")
-	}).Where("experiment_id = ?", experimentID).Find(&experimentNodes).Error; err != nil {
-			return fmt.Errorf("failed to fetch experiment nodes: %w", err)
+	if err := tx.Preload("Node", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "public_key")
+	}).Where("experiment_id = ? AND status = ?", experimentID, models.ExperimentNodeStatusPreparing).Find(&experimentNodes).Error; err != nil {
+		return fmt.Errorf("failed to fetch experiment nodes: %w", err)
 	}
 
 	file, err := os.OpenFile(csvFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-			return fmt.Errorf("failed to open CSV file: %w", err)
+		return fmt.Errorf("failed to open CSV file: %w", err)
 	}
 	defer file.Close()
 
 	for _, node := range experimentNodes {
-			if _, err := file.WriteString(node.Node.PublicKey + "\n"); err != nil {
-					return fmt.Errorf("failed to write to CSV file: %w", err)
-			}
+		if _, err := file.WriteString(node.Node.PublicKey + "\n"); err != nil {
+			return fmt.Errorf("failed to write to CSV file: %w", err)
+		}
 	}
 
 	return nil
@@ -313,7 +617,10 @@ func (h *ExperimentHandler) NodeTrainingStarted(c echo.Context) error {
 	experimentMutex.Lock()
 	defer experimentMutex.Unlock()
 
-	experimentID := c.Param("experimentID")
+	experimentID, err := parseExperimentID(c.Param("experimentID"))
+	if err != nil {
+		return err
+	}
 	node := c.Get("node").(models.Node)
 
 	var experimentNode models.ExperimentNode
@@ -325,75 +632,88 @@ func (h *ExperimentHandler) NodeTrainingStarted(c echo.Context) error {
 		return c.JSON(200, map[string]string{"status": "already acknowledged"})
 	}
 
+	if experimentNode.Status == models.ExperimentNodeStatusFailed {
+		return utils.NewBadRequestError("Node was marked as failed for this experiment")
+	}
+
 	experimentNode.Status = models.ExperimentNodeStatusTraining
 	if err := h.DB.Save(&experimentNode).Error; err != nil {
 		return utils.NewInternalServerError("Failed to update experiment node status")
 	}
 
-	// Check if all nodes have started training
-	var count int64
-	if err := h.DB.Model(&models.ExperimentNode{}).
-		Where("experiment_id = ? AND status = ?", experimentID, models.ExperimentNodeStatusTraining).
-		Count(&count).Error; err != nil {
-		return utils.NewInternalServerError("Failed to count training nodes")
-	}
-
-	var totalNodes int64
-	if err := h.DB.Model(&models.ExperimentNode{}).
-		Where("experiment_id = ?", experimentID).
-		Count(&totalNodes).Error; err != nil {
-		return utils.NewInternalServerError("Failed to count total nodes")
+	job, err := h.startIfNodesReady(experimentID)
+	if err != nil {
+		log.Printf("Error starting experiment %d: %v", experimentID, err)
+		return utils.NewInternalServerError("Failed to start the server process")
 	}
 
-	if count == totalNodes {
-		// All nodes have started training, start the server process
-		h.startServerProcess(experimentID)
-
-		// Update experiment status
-		if err := h.DB.Model(&models.Experiment{}).
-			Where("id = ?", experimentID).
-			Update("status", models.ExperimentNodeStatusTraining).Error; err != nil {
-			return utils.NewInternalServerError("Failed to update experiment status")
-		}
+	response := map[string]interface{}{"status": "acknowledged"}
+	if job != nil {
+		response["job_id"] = job.ID
 	}
-
-	return c.JSON(200, map[string]string{"status": "acknowledged"})
+	return c.JSON(200, response)
 }
 
-func (h *ExperimentHandler) startServerProcess(experimentID string) {
+func (h *ExperimentHandler) startServerProcess(experimentID uint) error {
 	var experiment models.Experiment
 	if err := h.DB.First(&experiment, experimentID).Error; err != nil {
-		log.Printf("Failed to find experiment %s: %v", experimentID, err)
-		return
+		return fmt.Errorf("failed to find experiment %d: %w", experimentID, err)
+	}
+
+	experimentIDStr := fmt.Sprintf("%d", experiment.ID)
+
+	run, err := activeRun(h.DB, experiment.ID)
+	if err != nil {
+		return fmt.Errorf("failed to find active run of experiment %d: %w", experimentID, err)
+	}
+
+	// The run trains the revision it was started with
+	basePath, err := runBasePath(h.DB, &experiment, run)
+	if err != nil {
+		return err
 	}
 
 	// Get the experiment name
-	parts := strings.Split(experiment.BasePath, "/")
+	parts := strings.Split(basePath, "/")
 	experimentName := parts[len(parts)-1]
 
-	if err := h.PythonEnv.InstallExperimentDependencies(experiment.BasePath); err != nil {
-		log.Printf("Failed to install experiment dependencies: %v", err)
-		return
+	// The overrides lost their types in the database, where integers became
+	// floats, so they are resolved against the run's revision once more
+	pyproject, err := utils.LoadPyProject(filepath.Join(basePath, "pyproject.toml"))
+	if err != nil {
+		return err
+	}
+	overrides, _, err := pyproject.ResolveRunConfig(run.RunConfigOverrides)
+	if err != nil {
+		return fmt.Errorf("invalid run config of run %d: %w", run.Number, err)
 	}
 
-	experimentIDStr := fmt.Sprintf("%d", experiment.ID)
+	if _, err := h.PythonEnv.RunFlwr(basePath, experimentIDStr, experimentName, utils.FormatRunConfig(overrides)); err != nil {
+		return fmt.Errorf("failed to run FLWR for experiment %d: %w", experimentID, err)
+	}
 
-	if err := h.PythonEnv.RunFlwr(experiment.BasePath, experimentIDStr, experimentName); err != nil {
-		log.Printf("Failed to run FLWR for experiment %s: %v", experimentID, err)
-		return
+	if federation, ok := h.PythonEnv.GetFederation(experimentIDStr); ok {
+		if err := h.DB.Model(run).Update("flwr_log", federation.FlwrLog).Error; err != nil {
+			log.Printf("Failed to record flwr log of experiment %d: %v", experimentID, err)
+		}
 	}
 
-	log.Printf("Starting server process for experiment ID: %s", experimentID)
+	log.Printf("Starting server process for experiment ID: %d", experimentID)
+	return nil
 }
 
 func (h *ExperimentHandler) StopTraining(c echo.Context) error {
-	return h.DB.Transaction(func(tx *gorm.DB) error {
-		experimentID := c.Param("id")
+	experimentMutex.Lock()
+	defer experimentMutex.Unlock()
 
-		// Stop the server process for this experiment
-		h.stopServerProcess(experimentID)
+	experimentID, err := parseExperimentID(c.Param("id"))
+	if err != nil {
+		return err
+	}
 
-		var experiment models.Experiment
+	var experiment models.Experiment
+	var halt *experimentHalt
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Preload("User", func(db *gorm.DB) *gorm.DB {
 			return db.Omit("Password")
 		}).
@@ -404,54 +724,64 @@ func (h *ExperimentHandler) StopTraining(c echo.Context) error {
 			return utils.NewNotFoundError("Experiment not found")
 		}
 
-		if experiment.Status != string(models.ExperimentNodeStatusTraining) && experiment.Status != string(models.ExperimentNodeStatusPreparing) {
+		if !experiment.Status.IsActive() {
 			return utils.NewBadRequestError("Experiment is not currently in training or preparing")
 		}
 
-		// Update experiment nodes status and create instructions
-		var experimentNodes []models.ExperimentNode
-		if err := tx.Where("experiment_id = ? AND status = ?", experiment.ID, models.ExperimentNodeStatusTraining).Find(&experimentNodes).Error; err != nil {
-			return utils.NewInternalServerError("Failed to fetch experiment nodes")
+		run, err := activeRun(tx, experiment.ID)
+		if err != nil {
+			return utils.NewInternalServerError("Failed to find the active run")
 		}
 
-		instructions := make([]store.NodeInstruction, len(experimentNodes))
-		for i, en := range experimentNodes {
-			en.Status = models.ExperimentNodeStatusStopped
-			if err := tx.Save(&en).Error; err != nil {
-				return utils.NewInternalServerError("Failed to update experiment node status")
-			}
-
-			instructions[i] = store.NodeInstruction{
-				NodeID: en.NodeID,
-				Instruction: models.Instruction{
-					Type:    models.InstructionStopTraining,
-					Payload: map[string]interface{}{"experiment_id": experiment.ID},
-				},
-			}
+		// Move the nodes of this experiment to STOPPED
+		halt, err = h.haltExperiment(tx, &experiment, models.ExperimentNodeStatusStopped)
+		if err != nil {
+			log.Printf("Error stopping experiment %d: %v", experimentID, err)
+			return utils.NewInternalServerError("Failed to stop experiment")
 		}
-		store.GlobalInstructionStore.AddInstructions(instructions)
 
 		// Update experiment status
-		experiment.Status = string(models.ExperimentNodeStatusStopped)
-		if err := tx.Save(&experiment).Error; err != nil {
-			return utils.NewInternalServerError("Failed to update experiment status")
+		if err := transitionExperiment(tx, &experiment, models.ExperimentStatusStopped, userActor(c), "stopped by user"); err != nil {
+			return err
+		}
+
+		if err := tx.Preload("Nodes").First(run, run.ID).Error; err != nil {
+			return utils.NewInternalServerError("Failed to fetch run")
 		}
+		experiment.Runs = []models.Run{*run}
 
-		return c.JSON(200, experiment)
+		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	// Stop the server process and the nodes only once the stop is committed
+	if err := h.completeHalt(halt); err != nil {
+		log.Printf("Error stopping experiment %d: %v", experimentID, err)
+		return utils.NewInternalServerError("Experiment stopped but failed to queue instructions for nodes")
+	}
+
+	return c.JSON(200, experiment)
 }
 
 func (h *ExperimentHandler) stopServerProcess(experimentID string) {
-	h.PythonEnv.CleanupSuperLink()
-	h.PythonEnv.CleanupFlwr()
+	if err := h.PythonEnv.StopFederation(experimentID); err != nil {
+		log.Printf("Error stopping federation of experiment %s: %v", experimentID, err)
+	}
 	fmt.Printf("Stopping server process for experiment ID: %s\n", experimentID)
 }
 
 func (h *ExperimentHandler) UpdateExperiment(c echo.Context) error {
-	experimentID := c.Param("id")
+	experimentID, err := parseExperimentID(c.Param("id"))
+	if err != nil {
+		return err
+	}
 
-	return h.DB.Transaction(func(tx *gorm.DB) error {
-		var experiment models.Experiment
+	var experiment models.Experiment
+	var revision *models.ExperimentRevision
+	var instructions []store.NodeInstruction
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Preload("User", func(db *gorm.DB) *gorm.DB {
 			return db.Omit("Password")
 		}).
@@ -463,51 +793,124 @@ func (h *ExperimentHandler) UpdateExperiment(c echo.Context) error {
 		}
 
 		// Check if the experiment is in TRAINING status
-		if experiment.Status == string(models.ExperimentNodeStatusTraining) ||
-			experiment.Status == string(models.ExperimentNodeStatusPreparing) {
+		if experiment.Status.IsActive() {
 			return utils.NewBadRequestError("Cannot update experiment while it is in training or preparing")
 		}
 
-		// Bind new experiment data
-		if err := c.Bind(&experiment); err != nil {
+		// Bind new experiment data, the status can only change through transitions
+		var request struct {
+			Name        string `json:"name" form:"name"`
+			Description string `json:"description" form:"description"`
+		}
+		if err := c.Bind(&request); err != nil {
 			return utils.NewBadRequestError("Invalid request payload")
 		}
+		if request.Name != "" {
+			experiment.Name = request.Name
+		}
+		if request.Description != "" {
+			experiment.Description = request.Description
+		}
 
-		updatedFiles, err := h.handleFileUpdates(c, &experiment)
+		if err := tx.Model(&experiment).Updates(map[string]interface{}{
+			"name":        experiment.Name,
+			"description": experiment.Description,
+		}).Error; err != nil {
+			return utils.NewInternalServerError("Failed to update experiment")
+		}
+
+		stagingDir, updatedFiles, err := h.handleFileUpdates(c, tx, &experiment)
 		if err != nil {
 			return err
 		}
+		if stagingDir == "" {
+			return nil
+		}
+		defer os.RemoveAll(stagingDir)
 
-		if err := tx.Save(&experiment).Error; err != nil {
-			return utils.NewInternalServerError("Failed to update experiment")
+		previous, err := h.currentRevision(tx, &experiment)
+		if err != nil {
+			return err
+		}
+
+		parts := strings.Split(experiment.BasePath, "/")
+		revision, err = h.createRevision(tx, &experiment, stagingDir, parts[len(parts)-1], userActor(c))
+		if err != nil || revision == nil {
+			// A nil revision means the files did not change
+			return err
+		}
+
+		if err := h.queueDependencyInstall(tx, revision); err != nil {
+			return err
+		}
+
+		diff := utils.DiffManifests(previous.Manifest, revision.Manifest)
+		instructions, err = h.notifyNodesAboutUpdate(tx, &experiment, revision, updatedFiles, diff)
+		if err != nil {
+			return err
 		}
 
-		if len(updatedFiles) > 0 {
-			if err := h.notifyNodesAboutUpdate(tx, &experiment, updatedFiles); err != nil {
+		if experiment.Status != models.ExperimentStatusDraft {
+			if err := transitionExperiment(tx, &experiment, models.ExperimentStatusAwaitingNodes, userActor(c), fmt.Sprintf("experiment files updated to revision %d", revision.Number)); err != nil {
 				return err
 			}
 		}
 
-		return c.JSON(200, experiment)
+		return nil
 	})
+	if err != nil {
+		discardRevision(revision)
+		return err
+	}
+
+	if err := store.GlobalInstructionStore.AddInstructions(instructions); err != nil {
+		log.Printf("Error queueing instructions: %v\n", err)
+		return utils.NewInternalServerError("Experiment updated but failed to queue instructions for nodes")
+	}
+
+	return c.JSON(200, experiment)
 }
 
-func (h *ExperimentHandler) handleFileUpdates(c echo.Context, experiment *models.Experiment) ([]string, error) {
+// handleFileUpdates stages a copy of the current revision with the uploaded
+// files applied and checks it. It returns an empty staging directory when no
+// files were uploaded.
+func (h *ExperimentHandler) handleFileUpdates(c echo.Context, tx *gorm.DB, experiment *models.Experiment) (string, []string, error) {
 	form, err := c.MultipartForm()
 	if err != nil {
-		return nil, utils.NewBadRequestError("Failed to parse multipart form")
+		return "", nil, utils.NewBadRequestError("Failed to parse multipart form")
+	}
+	if len(form.File) == 0 {
+		return "", nil, nil
 	}
-
-	var updatedFiles []string
 
 	// Get experiment name from BasePath
 	parts := strings.Split(experiment.BasePath, "/")
 	if len(parts) < 3 { // Should have: uploads/id/expname
-		return nil, utils.NewInternalServerError("Invalid base path format")
+		return "", nil, utils.NewInternalServerError("Invalid base path format")
 	}
 	experimentName := parts[len(parts)-1]
 
-	// Handle each uploaded file
+	current, err := h.currentRevision(tx, experiment)
+	if err != nil {
+		return "", nil, err
+	}
+
+	stagingDir, err := newStagingDir()
+	if err != nil {
+		return "", nil, utils.NewInternalServerError("Failed to create staging directory")
+	}
+	appDir := filepath.Join(stagingDir, experimentName)
+
+	// Revisions are immutable, so the update starts from a copy of the current one
+	for _, entry := range current.Manifest {
+		src := filepath.Join(experiment.BasePath, filepath.FromSlash(entry.Path))
+		if err := utils.CopyFile(src, filepath.Join(appDir, filepath.FromSlash(entry.Path))); err != nil {
+			os.RemoveAll(stagingDir)
+			return "", nil, utils.NewInternalServerError(fmt.Sprintf("Failed to copy %s: %v", entry.Path, err))
+		}
+	}
+
+	var updatedFiles []string
 	for _, fileHeaders := range form.File {
 		for _, fileHeader := range fileHeaders {
 			filename := filepath.Base(fileHeader.Filename)
@@ -515,132 +918,208 @@ func (h *ExperimentHandler) handleFileUpdates(c echo.Context, experiment *models
 
 			// Determine if file should go in base folder or inner folder
 			if filename == "pyproject.toml" {
-				filePath = filepath.Join(experiment.BasePath, filename)
+				filePath = filepath.Join(appDir, filename)
 			} else {
-				filePath = filepath.Join(experiment.BasePath, experimentName, filename)
-			}
-
-			// Open the uploaded file
-			src, err := fileHeader.Open()
-			if err != nil {
-				return nil, utils.NewInternalServerError(fmt.Sprintf("Failed to open uploaded file %s", filename))
-			}
-			defer src.Close()
-
-			// Create or truncate the destination file
-			dst, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-			if err != nil {
-				return nil, utils.NewInternalServerError(fmt.Sprintf("Failed to create destination file %s", filename))
+				filePath = filepath.Join(appDir, experimentName, filename)
 			}
-			defer dst.Close()
 
-			// Copy the contents
-			if _, err = io.Copy(dst, src); err != nil {
-				return nil, utils.NewInternalServerError(fmt.Sprintf("Failed to copy file contents for %s", filename))
+			if err := writeUploadedFile(fileHeader, filePath); err != nil {
+				os.RemoveAll(stagingDir)
+				return "", nil, utils.NewInternalServerError(fmt.Sprintf("Failed to store uploaded file %s", filename))
 			}
 
 			updatedFiles = append(updatedFiles, filename)
 		}
 	}
 
-	return updatedFiles, nil
+	// Reject an update that would break the experiment
+	if report := inspectPackage(stagingDir); len(report.Problems) > 0 {
+		os.RemoveAll(stagingDir)
+		return "", nil, utils.NewValidationError("Invalid experiment package", report.Problems)
+	}
+
+	return stagingDir, updatedFiles, nil
+}
+
+func writeUploadedFile(fileHeader *multipart.FileHeader, filePath string) error {
+	src, err := fileHeader.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
 }
 
-func (h *ExperimentHandler) notifyNodesAboutUpdate(tx *gorm.DB, experiment *models.Experiment, updatedFiles []string) error {
+// notifyNodesAboutUpdate sets the experiment's nodes back to pending and
+// returns the UPDATE_EXPERIMENT instructions to queue once the update commits.
+// The instructions carry the files diff added, modified and removed since the
+// previous revision.
+func (h *ExperimentHandler) notifyNodesAboutUpdate(tx *gorm.DB, experiment *models.Experiment, revision *models.ExperimentRevision, updatedFiles []string, diff utils.ManifestDiff) ([]store.NodeInstruction, error) {
 	var experimentNodes []models.ExperimentNode
 	if err := tx.Where("experiment_id = ?", experiment.ID).Find(&experimentNodes).Error; err != nil {
-		return utils.NewInternalServerError("Failed to fetch experiment nodes")
+		return nil, utils.NewInternalServerError("Failed to fetch experiment nodes")
 	}
 
+	changedFiles := manifestPaths(diff.Added, diff.Modified)
+	removedFiles := manifestPaths(diff.Removed)
+
 	// Create instructions for each node
+	var instructions []store.NodeInstruction
 	for _, en := range experimentNodes {
-		instruction := store.NodeInstruction{
+		instructions = append(instructions, store.NodeInstruction{
 			NodeID: en.NodeID,
 			Instruction: models.Instruction{
 				Type: models.InstructionUpdateExperiment,
 				Payload: map[string]interface{}{
 					"experiment_id": experiment.ID,
 					"files_path":    experiment.BasePath,
+					"revision":      revision.Number,
+					"manifest_hash": revision.ManifestHash,
+					"signature":     revision.Signature,
 					"updated_files": updatedFiles,
+					"changed_files": changedFiles,
+					"removed_files": removedFiles,
 				},
 			},
-		}
-		store.GlobalInstructionStore.AddInstructions([]store.NodeInstruction{instruction})
+		})
 
+		// Differences found against an earlier revision no longer apply
 		en.Status = models.ExperimentNodeStatusPending
+		en.MismatchedFiles = nil
+		en.RemovedFiles = nil
 		if err := tx.Save(&en).Error; err != nil {
-			return utils.NewInternalServerError("Failed to update experiment node status")
+			return nil, utils.NewInternalServerError("Failed to update experiment node status")
 		}
 	}
 
-	return nil
+	return instructions, nil
+}
+
+// manifestPaths returns the paths of the given manifest entries
+func manifestPaths(groups ...[]models.ManifestEntry) []string {
+	paths := []string{}
+	for _, group := range groups {
+		for _, entry := range group {
+			paths = append(paths, entry.Path)
+		}
+	}
+	return paths
 }
 
+// ReceiveChecksum compares the manifest hash a node computed over its copy of
+// the experiment package with the current revision. On a mismatch the node's
+// manifest, when sent, narrows the response down to the files that differ.
 func (h *ExperimentHandler) ReceiveChecksum(c echo.Context) error {
-	experimentID := c.Param("experimentID")
+	experimentID, err := parseExperimentID(c.Param("experimentID"))
+	if err != nil {
+		return err
+	}
 	nodeID := c.Get("node").(models.Node).ID
-	clientAppChecksum := c.FormValue("client_app_checksum")
-	pyprojectChecksum := c.FormValue("pyproject_checksum")
+	manifestHash := c.FormValue("manifest_hash")
 
-	fmt.Printf("Received checksum for experiment %s: client_app_checksum=%s, pyproject_checksum=%s\n",
-		experimentID, clientAppChecksum, pyprojectChecksum)
+	log.Printf("Received manifest hash for experiment %d from node %d: %s", experimentID, nodeID, manifestHash)
+
+	if manifestHash == "" {
+		return utils.NewBadRequestError("manifest_hash is required")
+	}
 
 	var experiment models.Experiment
 	if err := h.DB.First(&experiment, experimentID).Error; err != nil {
 		return utils.NewNotFoundError("Experiment not found")
 	}
 
-	// Get file paths
-	parts := strings.Split(experiment.BasePath, "/")
-	experimentName := parts[len(parts)-1]
-	pyprojectPath := filepath.Join(experiment.BasePath, "pyproject.toml")
-	clientAppPath := filepath.Join(experiment.BasePath, experimentName, "client_app.py")
-
-	// Calculate SHA-256 for pyproject.toml
-	pyprojectFile, err := os.Open(pyprojectPath)
+	current, err := h.currentRevision(h.DB, &experiment)
 	if err != nil {
-		return utils.NewInternalServerError(fmt.Sprintf("Failed to open pyproject.toml: %v", err))
+		return err
 	}
-	defer pyprojectFile.Close()
+	expected := current.Manifest
 
-	pyprojectHasher := sha256.New()
-	if _, err := io.Copy(pyprojectHasher, pyprojectFile); err != nil {
-		return utils.NewInternalServerError(fmt.Sprintf("Failed to calculate pyproject.toml hash: %v", err))
-	}
-	calculatedPyprojectHash := fmt.Sprintf("%x", pyprojectHasher.Sum(nil))
+	if manifestHash == current.ManifestHash {
+		if err := h.DB.Model(&models.ExperimentNode{}).
+			Where("experiment_id = ? AND node_id = ?", experimentID, nodeID).
+			Select("mismatched_files", "removed_files").
+			Updates(models.ExperimentNode{}).Error; err != nil {
+			return utils.NewInternalServerError("Failed to update experiment node")
+		}
 
-	// Calculate SHA-256 for client_app.py
-	clientAppFile, err := os.Open(clientAppPath)
-	if err != nil {
-		return utils.NewInternalServerError(fmt.Sprintf("Failed to open client_app.py: %v", err))
+		return c.JSON(200, map[string]string{
+			"status": "verified",
+		})
 	}
-	defer clientAppFile.Close()
 
-	clientAppHasher := sha256.New()
-	if _, err := io.Copy(clientAppHasher, clientAppFile); err != nil {
-		return utils.NewInternalServerError(fmt.Sprintf("Failed to calculate client_app.py hash: %v", err))
+	// Without the node's manifest every file has to be considered different
+	var reported []models.ManifestEntry
+	if manifestJSON := c.FormValue("manifest"); manifestJSON != "" {
+		if err := json.Unmarshal([]byte(manifestJSON), &reported); err != nil {
+			return utils.NewBadRequestError("Invalid manifest")
+		}
+		if utils.ManifestHash(reported) != manifestHash {
+			return utils.NewBadRequestError("manifest does not match manifest_hash")
+		}
 	}
-	calculatedClientAppHash := fmt.Sprintf("%x", clientAppHasher.Sum(nil))
+	diff := utils.DiffManifests(reported, expected)
 
-	// Compare checksums
-	if calculatedPyprojectHash != pyprojectChecksum || calculatedClientAppHash != clientAppChecksum {
-		if err := h.DB.Model(&models.ExperimentNode{}).
-			Where("experiment_id = ? AND node_id = ?", experimentID, nodeID).
-			Update("status", models.ExperimentNodeStatusChecksumMismatch).Error; err != nil {
-			return utils.NewInternalServerError("Failed to update experiment node status")
-		}
+	var mismatchedFiles, removedFiles []string
+	var fields []utils.FieldError
+	for _, entry := range diff.Added {
+		mismatchedFiles = append(mismatchedFiles, entry.Path)
+		fields = append(fields, utils.FieldError{Field: entry.Path, Message: "missing"})
+	}
+	for _, entry := range diff.Modified {
+		mismatchedFiles = append(mismatchedFiles, entry.Path)
+		fields = append(fields, utils.FieldError{Field: entry.Path, Message: "modified"})
+	}
+	for _, entry := range diff.Removed {
+		removedFiles = append(removedFiles, entry.Path)
+		fields = append(fields, utils.FieldError{Field: entry.Path, Message: "unexpected"})
+	}
 
-		return utils.NewBadRequestError("checksum mismatch")
+	// Selected so that empty lists overwrite the ones of an earlier mismatch
+	if err := h.DB.Model(&models.ExperimentNode{}).
+		Where("experiment_id = ? AND node_id = ?", experimentID, nodeID).
+		Select("status", "mismatched_files", "removed_files").
+		Updates(models.ExperimentNode{
+			Status:          models.ExperimentNodeStatusChecksumMismatch,
+			MismatchedFiles: mismatchedFiles,
+			RemovedFiles:    removedFiles,
+		}).Error; err != nil {
+		return utils.NewInternalServerError("Failed to update experiment node status")
 	}
 
-	return c.JSON(200, map[string]string{
-		"status": "verified",
-	})
+	return &utils.AppError{
+		StatusCode: http.StatusBadRequest,
+		Message:    "checksum mismatch",
+		Fields:     fields,
+	}
 }
 
+// UpdateFiles resends the files that differ on every node with a checksum
+// mismatch, as reported by ReceiveChecksum, and tells the node which files to
+// delete
 func (h *ExperimentHandler) UpdateFiles(c echo.Context) error {
-	experimentID := c.Param("experimentID")
-	updatedFiles := []string{"pyproject.toml", "client_app.py"}
+	experimentID, err := parseExperimentID(c.Param("experimentID"))
+	if err != nil {
+		return err
+	}
+
+	var experiment models.Experiment
+	if err := h.DB.First(&experiment, experimentID).Error; err != nil {
+		return utils.NewNotFoundError("Experiment not found")
+	}
+
+	current, err := h.currentRevision(h.DB, &experiment)
+	if err != nil {
+		return err
+	}
+	expected := current.Manifest
 
 	// Grab the nodes that have a checksum mismatch
 	var experimentNodes []models.ExperimentNode
@@ -649,17 +1128,38 @@ func (h *ExperimentHandler) UpdateFiles(c echo.Context) error {
 	}
 
 	for _, en := range experimentNodes {
+		// Nodes without a recorded difference get every file
+		changedFiles := en.MismatchedFiles
+		if len(changedFiles) == 0 && len(en.RemovedFiles) == 0 {
+			for _, entry := range expected {
+				changedFiles = append(changedFiles, entry.Path)
+			}
+		}
+
+		updatedFiles := make([]string, len(changedFiles))
+		for i, file := range changedFiles {
+			updatedFiles[i] = path.Base(file)
+		}
+
 		instruction := store.NodeInstruction{
 			NodeID: en.NodeID,
 			Instruction: models.Instruction{
 				Type: models.InstructionUpdateExperiment,
 				Payload: map[string]interface{}{
 					"experiment_id": en.ExperimentID,
+					"files_path":    experiment.BasePath,
+					"revision":      current.Number,
+					"manifest_hash": current.ManifestHash,
+					"signature":     current.Signature,
 					"updated_files": updatedFiles,
+					"changed_files": changedFiles,
+					"removed_files": en.RemovedFiles,
 				},
 			},
 		}
-		store.GlobalInstructionStore.AddInstructions([]store.NodeInstruction{instruction})
+		if err := store.GlobalInstructionStore.AddInstructions([]store.NodeInstruction{instruction}); err != nil {
+			return utils.NewInternalServerError("Failed to queue instructions for nodes")
+		}
 	}
 
 	return c.JSON(200, map[string]string{"status": "acknowledged"})
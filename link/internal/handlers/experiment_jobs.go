@@ -0,0 +1,436 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"link/internal/jobs"
+	"link/internal/models"
+	"link/internal/store"
+	"link/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// Job types run in the background for experiments
+const (
+	JobInstallDependencies = "install_dependencies"
+	JobStartSuperLink      = "start_superlink"
+	JobStartServer         = "start_server"
+	JobExtractArchive      = "extract_archive"
+	JobCleanup             = "cleanup"
+)
+
+type runJobPayload struct {
+	RunID uint `json:"run_id"`
+}
+
+type revisionJobPayload struct {
+	ExperimentID uint `json:"experiment_id"`
+	Revision     int  `json:"revision"`
+}
+
+type extractArchivePayload struct {
+	UploadID      uint           `json:"upload_id"`
+	SelectedNodes []selectedNode `json:"selected_nodes"`
+	Normalize     bool           `json:"normalize"`
+	Actor         string         `json:"actor"`
+}
+
+// RegisterJobs registers the handlers of the experiment jobs with the runner
+func (h *ExperimentHandler) RegisterJobs(runner *jobs.Runner) {
+	runner.Register(JobInstallDependencies, h.installDependencies)
+	runner.Register(JobStartSuperLink, h.startSuperLinkJob)
+	runner.Register(JobStartServer, h.startServerJob)
+	runner.Register(JobExtractArchive, h.extractArchiveJob)
+	runner.Register(JobCleanup, h.cleanupJob)
+
+	runner.OnInterrupted(JobStartSuperLink, h.interruptedStart)
+	runner.OnInterrupted(JobStartServer, h.interruptedStart)
+}
+
+// queueDependencyInstall prepares the Python environment of a new revision
+// ahead of its first run
+func (h *ExperimentHandler) queueDependencyInstall(tx *gorm.DB, revision *models.ExperimentRevision) error {
+	_, err := h.Jobs.Enqueue(tx, JobInstallDependencies, revisionJobPayload{
+		ExperimentID: revision.ExperimentID,
+		Revision:     revision.Number,
+	}, jobs.Options{ExperimentID: &revision.ExperimentID, MaxAttempts: 3})
+	if err != nil {
+		return utils.NewInternalServerError("Failed to queue the dependency installation")
+	}
+	return nil
+}
+
+func (h *ExperimentHandler) installDependencies(ctx context.Context, job *models.Job, logger *jobs.Logger) error {
+	var payload revisionJobPayload
+	if err := jobs.DecodePayload(job, &payload); err != nil {
+		return err
+	}
+
+	var revision models.ExperimentRevision
+	if err := h.DB.Where("experiment_id = ? AND number = ?", payload.ExperimentID, payload.Revision).First(&revision).Error; err != nil {
+		return fmt.Errorf("failed to find revision %d of experiment %d: %w", payload.Revision, payload.ExperimentID, err)
+	}
+
+	logger.Printf("Installing the dependencies of revision %d", revision.Number)
+	venv, err := h.PythonEnv.EnsureExperimentEnv(revision.BasePath)
+	if err != nil {
+		return err
+	}
+
+	logger.Printf("Python environment %s is ready", venv.Key)
+	return nil
+}
+
+// startSuperLinkJob installs the environment of a run, starts the SuperLink
+// of its reserved federation, waits until it accepts connections and sends
+// START_TRAINING to the run's nodes. When any of this goes wrong the start is
+// rolled back, so the experiment can simply be started again.
+func (h *ExperimentHandler) startSuperLinkJob(ctx context.Context, job *models.Job, logger *jobs.Logger) error {
+	var payload runJobPayload
+	if err := jobs.DecodePayload(job, &payload); err != nil {
+		return err
+	}
+
+	var run models.Run
+	if err := h.DB.First(&run, payload.RunID).Error; err != nil {
+		return fmt.Errorf("failed to find run %d: %w", payload.RunID, err)
+	}
+	var experiment models.Experiment
+	if err := h.DB.First(&experiment, run.ExperimentID).Error; err != nil {
+		return fmt.Errorf("failed to find experiment %d: %w", run.ExperimentID, err)
+	}
+
+	// The environment is installed without holding experimentMutex
+	var venv *utils.VirtualEnv
+	basePath, envErr := runBasePath(h.DB, &experiment, &run)
+	if envErr == nil {
+		logger.Printf("Preparing the Python environment of revision %d", run.Revision)
+		venv, envErr = h.PythonEnv.EnsureExperimentEnv(basePath)
+	}
+
+	started, err := h.startSuperLink(&experiment, &run, venv, envErr, logger)
+	if !started {
+		return err
+	}
+
+	// So is the wait for the SuperLink to accept connections
+	timeout := h.Config.Federation.ReadinessTimeout
+	logger.Printf("Waiting up to %s for the SuperLink to accept connections", timeout)
+	readyErr := h.PythonEnv.WaitForSuperLink(fmt.Sprintf("%d", experiment.ID), timeout)
+
+	experimentMutex.Lock()
+	defer experimentMutex.Unlock()
+
+	if stopped, err := runStopped(h.DB, &run, logger); stopped || err != nil {
+		return err
+	}
+
+	federation, ok := h.PythonEnv.GetFederation(fmt.Sprintf("%d", experiment.ID))
+	if !ok {
+		return h.rollbackStart(&run, fmt.Errorf("federation of experiment %d is gone", experiment.ID))
+	}
+	// The SuperLink may also have exited since it became ready
+	if exitCode, exited := federation.SuperLinkExited(); readyErr == nil && exited {
+		readyErr = fmt.Errorf("SuperLink exited with code %d, see %s", exitCode, federation.SuperLinkLog)
+	}
+	if readyErr != nil {
+		return h.rollbackStart(&run, fmt.Errorf("SuperLink is not ready: %w", readyErr))
+	}
+	logger.Printf("SuperLink is ready")
+
+	var runNodes []models.RunNode
+	if err := h.DB.Where("run_id = ?", run.ID).Find(&runNodes).Error; err != nil {
+		return h.rollbackStart(&run, fmt.Errorf("failed to fetch run nodes: %w", err))
+	}
+
+	instructions := make([]store.NodeInstruction, len(runNodes))
+	for i, rn := range runNodes {
+		instructions[i] = store.NodeInstruction{
+			NodeID: rn.NodeID,
+			Instruction: models.Instruction{
+				Type:    models.InstructionStartTraining,
+				Payload: h.startTrainingPayload(experiment.ID, federation),
+			},
+		}
+	}
+	if err := store.GlobalInstructionStore.AddInstructions(instructions); err != nil {
+		return h.rollbackStart(&run, fmt.Errorf("failed to queue instructions for nodes: %w", err))
+	}
+	// From now on an exit of the SuperLink fails the experiment
+	federation.Ready = true
+
+	logger.Printf("Sent START_TRAINING to %d nodes", len(instructions))
+	return nil
+}
+
+// startSuperLink starts the SuperLink of a run that is still preparing and
+// reports whether it did. A failed start is rolled back.
+func (h *ExperimentHandler) startSuperLink(experiment *models.Experiment, run *models.Run, venv *utils.VirtualEnv, envErr error, logger *jobs.Logger) (bool, error) {
+	experimentMutex.Lock()
+	defer experimentMutex.Unlock()
+
+	if stopped, err := runStopped(h.DB, run, logger); stopped || err != nil {
+		return false, err
+	}
+
+	if envErr != nil {
+		return false, h.rollbackStart(run, fmt.Errorf("failed to install the experiment dependencies: %w", envErr))
+	}
+	logger.Printf("Using Python environment %s", venv.Key)
+
+	federation, err := h.PythonEnv.StartSuperLink(fmt.Sprintf("%d", experiment.ID), venv, func(keysFile string) error {
+		return h.writeNodeKeysToCSV(h.DB, experiment.ID, keysFile)
+	})
+	if err != nil {
+		return false, h.rollbackStart(run, err)
+	}
+	logger.Printf("Started SuperLink with the Fleet API on port %d and the Exec API on port %d", federation.FleetPort, federation.ExecPort)
+
+	if err := h.DB.Model(run).Updates(map[string]interface{}{
+		"python_env":     venv.Key,
+		"super_link_log": federation.SuperLinkLog,
+	}).Error; err != nil {
+		return false, h.rollbackStart(run, fmt.Errorf("failed to update run: %w", err))
+	}
+
+	return true, nil
+}
+
+// runStopped reloads a run and reports whether it was stopped in the meantime.
+// The caller must hold experimentMutex.
+func runStopped(db *gorm.DB, run *models.Run, logger *jobs.Logger) (bool, error) {
+	if err := db.First(run, run.ID).Error; err != nil {
+		return false, fmt.Errorf("failed to find run %d: %w", run.ID, err)
+	}
+	if run.Status != models.ExperimentStatusPreparing {
+		logger.Printf("Run %d is %s, not starting it", run.Number, run.Status)
+		return true, nil
+	}
+	return false, nil
+}
+
+// startServerJob runs flwr once every remaining node of the experiment has
+// started training
+func (h *ExperimentHandler) startServerJob(ctx context.Context, job *models.Job, logger *jobs.Logger) error {
+	var payload runJobPayload
+	if err := jobs.DecodePayload(job, &payload); err != nil {
+		return err
+	}
+
+	experimentMutex.Lock()
+	defer experimentMutex.Unlock()
+
+	var run models.Run
+	if err := h.DB.First(&run, payload.RunID).Error; err != nil {
+		return fmt.Errorf("failed to find run %d: %w", payload.RunID, err)
+	}
+	var experiment models.Experiment
+	if err := h.DB.First(&experiment, run.ExperimentID).Error; err != nil {
+		return fmt.Errorf("failed to find experiment %d: %w", run.ExperimentID, err)
+	}
+
+	if experiment.Status != models.ExperimentStatusPreparing {
+		logger.Printf("Experiment %d is %s, not starting flwr", experiment.ID, experiment.Status)
+		return nil
+	}
+
+	if err := h.startServerProcess(experiment.ID); err != nil {
+		return h.abortStart(experiment.ID, fmt.Errorf("server process failed to start: %w", err))
+	}
+	logger.Printf("Started flwr run for run %d", run.Number)
+
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		return transitionExperiment(tx, &experiment, models.ExperimentStatusTraining, systemActor, "all remaining nodes started training")
+	})
+}
+
+// rollbackStart undoes the start of a run whose SuperLink could not be
+// started: the run fails with cause, the experiment returns to READY, its
+// nodes to ACCEPTED and the federation slot is released. It returns cause.
+// The caller must hold experimentMutex.
+func (h *ExperimentHandler) rollbackStart(run *models.Run, cause error) error {
+	log.Printf("Rolling back the start of run %d of experiment %d: %v", run.Number, run.ExperimentID, cause)
+
+	rolledBack := false
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// A run that was stopped in the meantime has nothing left to undo
+		if err := tx.First(run, run.ID).Error; err != nil {
+			return fmt.Errorf("failed to find run: %w", err)
+		}
+		if run.Status != models.ExperimentStatusPreparing {
+			return nil
+		}
+
+		var experiment models.Experiment
+		if err := tx.First(&experiment, run.ExperimentID).Error; err != nil {
+			return fmt.Errorf("failed to find experiment: %w", err)
+		}
+
+		if err := tx.Model(run).Updates(map[string]interface{}{
+			"status":      models.ExperimentStatusFailed,
+			"error":       cause.Error(),
+			"finished_at": time.Now(),
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update run: %w", err)
+		}
+
+		if err := tx.Model(&models.RunNode{}).Where("run_id = ?", run.ID).
+			Update("status", models.ExperimentNodeStatusAccepted).Error; err != nil {
+			return fmt.Errorf("failed to update run nodes: %w", err)
+		}
+
+		if err := tx.Model(&models.ExperimentNode{}).
+			Where("experiment_id = ? AND status = ?", experiment.ID, models.ExperimentNodeStatusPreparing).
+			Update("status", models.ExperimentNodeStatusAccepted).Error; err != nil {
+			return fmt.Errorf("failed to update experiment nodes: %w", err)
+		}
+
+		rolledBack = true
+		return transitionExperiment(tx, &experiment, models.ExperimentStatusReady, systemActor, fmt.Sprintf("start rolled back: %v", cause))
+	})
+	if err != nil {
+		log.Printf("Failed to roll back the start of experiment %d: %v", run.ExperimentID, err)
+		return h.abortStart(run.ExperimentID, cause)
+	}
+
+	if rolledBack {
+		// The federation slot is free again, give it to the next queued experiment
+		h.stopServerProcess(fmt.Sprintf("%d", run.ExperimentID))
+		h.scheduleQueueDrain()
+	}
+
+	return cause
+}
+
+// interruptedStart rolls back a run whose start was interrupted by a restart
+// of the link. Its federation went away with the link.
+func (h *ExperimentHandler) interruptedStart(job *models.Job) {
+	var payload runJobPayload
+	if err := jobs.DecodePayload(job, &payload); err != nil {
+		log.Printf("Failed to roll back interrupted job %d: %v", job.ID, err)
+		return
+	}
+
+	experimentMutex.Lock()
+	defer experimentMutex.Unlock()
+
+	var run models.Run
+	if err := h.DB.First(&run, payload.RunID).Error; err != nil {
+		log.Printf("Failed to find run %d of interrupted job %d: %v", payload.RunID, job.ID, err)
+		return
+	}
+	if run.Status != models.ExperimentStatusPreparing {
+		return
+	}
+
+	h.rollbackStart(&run, errors.New("start interrupted by a restart of the link"))
+}
+
+// abortStart fails an experiment that could not be started and returns err.
+// The caller must hold experimentMutex.
+func (h *ExperimentHandler) abortStart(experimentID uint, err error) error {
+	log.Printf("Failed to start experiment %d: %v", experimentID, err)
+	if finishErr := h.finishExperiment(experimentID, models.ExperimentStatusFailed, nil, err.Error()); finishErr != nil {
+		log.Printf("Failed to mark experiment %d as failed: %v", experimentID, finishErr)
+	}
+	return err
+}
+
+// extractArchiveJob creates the first revision of an experiment from its
+// chunked upload and sends the experiment to the selected nodes
+func (h *ExperimentHandler) extractArchiveJob(ctx context.Context, job *models.Job, logger *jobs.Logger) error {
+	var payload extractArchivePayload
+	if err := jobs.DecodePayload(job, &payload); err != nil {
+		return err
+	}
+	if job.ExperimentID == nil {
+		return fmt.Errorf("job %d has no experiment", job.ID)
+	}
+
+	var experiment models.Experiment
+	if err := h.DB.First(&experiment, *job.ExperimentID).Error; err != nil {
+		return fmt.Errorf("failed to find experiment %d: %w", *job.ExperimentID, err)
+	}
+	if experiment.Status != models.ExperimentStatusDraft || experiment.Revision != 0 {
+		logger.Printf("Experiment %d already has its files", experiment.ID)
+		return nil
+	}
+
+	var upload models.Upload
+	if err := h.DB.First(&upload, payload.UploadID).Error; err != nil {
+		return fmt.Errorf("failed to find upload %d: %w", payload.UploadID, err)
+	}
+	archive, err := openUploadArchive(&upload)
+	if err != nil {
+		return err
+	}
+	defer archive.file.Close()
+
+	logger.Printf("Extracting %s (%d bytes)", upload.FileName, upload.Size)
+	stagingDir, appFolder, err := h.stageExperimentPackage(archive, payload.Normalize)
+	if err != nil {
+		return jobError(logger, err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	var revision *models.ExperimentRevision
+	var instructions []store.NodeInstruction
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		revision, err = h.createRevision(tx, &experiment, stagingDir, appFolder, payload.Actor)
+		if err != nil {
+			return err
+		}
+
+		if err := createExperimentNodes(tx, &experiment, payload.SelectedNodes); err != nil {
+			return err
+		}
+
+		if err := h.queueDependencyInstall(tx, revision); err != nil {
+			return err
+		}
+
+		if err := transitionExperiment(tx, &experiment, models.ExperimentStatusAwaitingNodes, payload.Actor, "experiment sent to the selected nodes"); err != nil {
+			return err
+		}
+
+		// Queued with the revision, so a retry never finds the files without
+		// the instructions
+		instructions = newExperimentInstructions(&experiment, revision, payload.SelectedNodes)
+		if err := store.GlobalInstructionStore.CreateInstructions(tx, instructions); err != nil {
+			return fmt.Errorf("failed to queue instructions for nodes: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		discardRevision(revision)
+		return jobError(logger, err)
+	}
+	logger.Printf("Created revision %d in %s", revision.Number, revision.BasePath)
+
+	if err := removeUpload(h.DB, &upload); err != nil {
+		logger.Printf("Failed to remove upload %d: %v", upload.ID, err)
+	}
+
+	store.GlobalInstructionStore.Notify(instructions)
+	logger.Printf("Sent NEW_EXPERIMENT to %d nodes", len(instructions))
+	return nil
+}
+
+// jobError logs the field errors of a validation error, which the job's
+// error message alone would not show
+func jobError(logger *jobs.Logger, err error) error {
+	var appErr *utils.AppError
+	if errors.As(err, &appErr) {
+		for _, field := range appErr.Fields {
+			logger.Printf("%s: %s", field.Field, field.Message)
+		}
+	}
+	return err
+}
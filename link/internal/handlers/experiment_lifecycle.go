@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"link/internal/jobs"
+	"link/internal/models"
+	"link/internal/store"
+	"link/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// activeNodeStatuses are the experiment node states of a node taking part in a
+// running experiment
+var activeNodeStatuses = []models.ExperimentNodeStatus{
+	models.ExperimentNodeStatusPreparing,
+	models.ExperimentNodeStatusTraining,
+}
+
+const systemActor = "system"
+
+func userActor(c echo.Context) string {
+	if userID, ok := c.Get("user_id").(float64); ok {
+		return fmt.Sprintf("user:%d", uint(userID))
+	}
+	if node, ok := c.Get("node").(models.Node); ok {
+		return nodeActor(node.ID)
+	}
+	return "unknown"
+}
+
+func nodeActor(nodeID uint) string {
+	return fmt.Sprintf("node:%d", nodeID)
+}
+
+// transitionExperiment moves an experiment to the next status when the
+// transition table allows it and records the change in the status history.
+// Every experiment status change must go through here.
+func transitionExperiment(tx *gorm.DB, experiment *models.Experiment, next models.ExperimentStatus, actor, reason string) error {
+	current := experiment.Status
+	if current == next {
+		return nil
+	}
+
+	if !current.CanTransitionTo(next) {
+		return utils.NewBadRequestError(fmt.Sprintf("Experiment cannot move from %s to %s", current, next))
+	}
+
+	if err := tx.Model(&models.Experiment{}).Where("id = ?", experiment.ID).Update("status", next).Error; err != nil {
+		return fmt.Errorf("failed to update experiment status: %w", err)
+	}
+
+	transition := models.ExperimentStatusTransition{
+		ExperimentID: experiment.ID,
+		FromStatus:   current,
+		ToStatus:     next,
+		Actor:        actor,
+		Reason:       reason,
+	}
+	if err := tx.Create(&transition).Error; err != nil {
+		return fmt.Errorf("failed to record experiment status transition: %w", err)
+	}
+
+	experiment.Status = next
+
+	// The run in progress follows the experiment through training to its outcome
+	return syncActiveRun(tx, experiment.ID, next)
+}
+
+// activeRun returns the run of an experiment that is preparing or training
+func activeRun(tx *gorm.DB, experimentID uint) (*models.Run, error) {
+	var run models.Run
+	err := tx.Where("experiment_id = ? AND status IN ?", experimentID, models.ActiveExperimentStatuses).
+		Order("number DESC").
+		First(&run).Error
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func syncActiveRun(tx *gorm.DB, experimentID uint, status models.ExperimentStatus) error {
+	switch status {
+	case models.ExperimentStatusTraining,
+		models.ExperimentStatusCompleted,
+		models.ExperimentStatusFailed,
+		models.ExperimentStatusStopped:
+	default:
+		return nil
+	}
+
+	run, err := activeRun(tx, experimentID)
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find active run: %w", err)
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"status": status}
+	if status == models.ExperimentStatusTraining {
+		updates["started_at"] = now
+	} else {
+		updates["finished_at"] = now
+	}
+
+	if err := tx.Model(run).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update run status: %w", err)
+	}
+
+	if status == models.ExperimentStatusTraining {
+		return nil
+	}
+
+	// Keep the final status of every node that took part in the run
+	var runNodes []models.RunNode
+	if err := tx.Where("run_id = ?", run.ID).Find(&runNodes).Error; err != nil {
+		return fmt.Errorf("failed to fetch run nodes: %w", err)
+	}
+
+	for _, rn := range runNodes {
+		var en models.ExperimentNode
+		if err := tx.Where("experiment_id = ? AND node_id = ? AND metadata_id = ?", experimentID, rn.NodeID, rn.MetadataID).
+			First(&en).Error; err != nil {
+			continue
+		}
+
+		if err := tx.Model(&models.RunNode{}).
+			Where("run_id = ? AND node_id = ? AND metadata_id = ?", rn.RunID, rn.NodeID, rn.MetadataID).
+			Update("status", en.Status).Error; err != nil {
+			return fmt.Errorf("failed to update run node status: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// syncNodeReadiness moves an experiment waiting for nodes to READY once one of
+// its nodes has accepted it, and back when none of them has
+func syncNodeReadiness(tx *gorm.DB, experiment *models.Experiment, actor string) error {
+	if experiment.Status != models.ExperimentStatusAwaitingNodes && experiment.Status != models.ExperimentStatusReady {
+		return nil
+	}
+
+	var accepted int64
+	if err := tx.Model(&models.ExperimentNode{}).
+		Where("experiment_id = ? AND status = ?", experiment.ID, models.ExperimentNodeStatusAccepted).
+		Count(&accepted).Error; err != nil {
+		return fmt.Errorf("failed to count accepted nodes: %w", err)
+	}
+
+	if accepted > 0 {
+		return transitionExperiment(tx, experiment, models.ExperimentStatusReady, actor, "a node accepted the experiment")
+	}
+	return transitionExperiment(tx, experiment, models.ExperimentStatusAwaitingNodes, actor, "no node has accepted the experiment")
+}
+
+// experimentHalt is what is left to do once the halt of an experiment has
+// been committed
+type experimentHalt struct {
+	experimentID uint
+	instructions []store.NodeInstruction
+}
+
+// haltExperiment moves the active nodes of an experiment to nodeStatus and
+// prepares their STOP_TRAINING instructions. The caller is responsible for
+// updating the experiment status and for calling completeHalt once tx has
+// been committed, so nothing is torn down when the transaction rolls back.
+func (h *ExperimentHandler) haltExperiment(tx *gorm.DB, experiment *models.Experiment, nodeStatus models.ExperimentNodeStatus) (*experimentHalt, error) {
+	var experimentNodes []models.ExperimentNode
+	if err := tx.Where("experiment_id = ? AND status IN ?", experiment.ID, activeNodeStatuses).Find(&experimentNodes).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch experiment nodes: %w", err)
+	}
+
+	halt := &experimentHalt{
+		experimentID: experiment.ID,
+		instructions: make([]store.NodeInstruction, len(experimentNodes)),
+	}
+	for i, en := range experimentNodes {
+		en.Status = nodeStatus
+		if err := tx.Save(&en).Error; err != nil {
+			return nil, fmt.Errorf("failed to update experiment node status: %w", err)
+		}
+
+		halt.instructions[i] = store.NodeInstruction{
+			NodeID: en.NodeID,
+			Instruction: models.Instruction{
+				Type:    models.InstructionStopTraining,
+				Payload: map[string]interface{}{"experiment_id": experiment.ID},
+			},
+		}
+	}
+
+	return halt, nil
+}
+
+// completeHalt tears down the federation processes of a halted experiment and
+// tells its nodes to stop training. A nil halt is ignored.
+func (h *ExperimentHandler) completeHalt(halt *experimentHalt) error {
+	if halt == nil {
+		return nil
+	}
+
+	h.stopServerProcess(fmt.Sprintf("%d", halt.experimentID))
+
+	// The federation slot is free again, give it to the next queued experiment
+	h.scheduleQueueDrain()
+
+	if err := store.GlobalInstructionStore.AddInstructions(halt.instructions); err != nil {
+		return fmt.Errorf("failed to queue stop instructions: %w", err)
+	}
+
+	return nil
+}
+
+// startIfNodesReady queues the job launching the server process once no node
+// of the experiment is still preparing and at least one is training. It
+// returns the queued job, if any.
+func (h *ExperimentHandler) startIfNodesReady(experimentID uint) (*models.Job, error) {
+	var experiment models.Experiment
+	if err := h.DB.First(&experiment, experimentID).Error; err != nil {
+		return nil, fmt.Errorf("failed to find experiment: %w", err)
+	}
+
+	if experiment.Status != models.ExperimentStatusPreparing {
+		return nil, nil
+	}
+
+	var preparing, training int64
+	if err := h.DB.Model(&models.ExperimentNode{}).
+		Where("experiment_id = ? AND status = ?", experimentID, models.ExperimentNodeStatusPreparing).
+		Count(&preparing).Error; err != nil {
+		return nil, fmt.Errorf("failed to count preparing nodes: %w", err)
+	}
+
+	if err := h.DB.Model(&models.ExperimentNode{}).
+		Where("experiment_id = ? AND status = ?", experimentID, models.ExperimentNodeStatusTraining).
+		Count(&training).Error; err != nil {
+		return nil, fmt.Errorf("failed to count training nodes: %w", err)
+	}
+
+	if preparing > 0 || training == 0 {
+		return nil, nil
+	}
+
+	run, err := activeRun(h.DB, experiment.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find active run: %w", err)
+	}
+
+	// All remaining nodes have started training, start the server process
+	return h.Jobs.Enqueue(h.DB, JobStartServer, runJobPayload{RunID: run.ID}, jobs.Options{ExperimentID: &experiment.ID, Unique: true})
+}
+
+// HandleFederationEvent completes or fails an experiment when a process of
+// its federation exits: flwr according to its exit code, the SuperLink always
+// with a failure. Events of processes that were stopped by the link are
+// ignored, and so are exits of a SuperLink that is not ready yet, which the
+// start_superlink job rolls back.
+func (h *ExperimentHandler) HandleFederationEvent(event utils.FederationEvent) {
+	if event.Type != utils.FederationEventExited {
+		return
+	}
+
+	experimentMutex.Lock()
+	defer experimentMutex.Unlock()
+
+	federation, ok := h.PythonEnv.GetFederation(event.ExperimentID)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(event.ExperimentID, 10, 64)
+	if err != nil {
+		log.Printf("Ignoring federation event of unknown experiment %q", event.ExperimentID)
+		return
+	}
+	experimentID := uint(id)
+
+	exitCode := event.ExitCode
+	switch {
+	case event.Kind == utils.ProcessFlwr && federation.Flwr == event.Process:
+		status := models.ExperimentStatusCompleted
+		if exitCode != 0 {
+			status = models.ExperimentStatusFailed
+		}
+		if err := h.finishExperiment(experimentID, status, &exitCode, fmt.Sprintf("flwr run exited with code %d", exitCode)); err != nil {
+			log.Printf("Failed to finish experiment %d: %v", experimentID, err)
+		}
+	case event.Kind == utils.ProcessSuperLink && federation.SuperLink == event.Process && federation.Ready:
+		if err := h.finishExperiment(experimentID, models.ExperimentStatusFailed, nil, fmt.Sprintf("SuperLink exited with code %d", exitCode)); err != nil {
+			log.Printf("Failed to finish experiment %d: %v", experimentID, err)
+		}
+	}
+}
+
+// finishExperiment moves a running experiment and its active nodes to the given
+// final status, stops the nodes and tears down the SuperLink. Experiments that
+// were already stopped or failed in the meantime are left untouched.
+func (h *ExperimentHandler) finishExperiment(experimentID uint, status models.ExperimentStatus, exitCode *int, reason string) error {
+	var halt *experimentHalt
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var experiment models.Experiment
+		if err := tx.First(&experiment, experimentID).Error; err != nil {
+			return fmt.Errorf("failed to find experiment: %w", err)
+		}
+
+		if !experiment.Status.IsActive() {
+			return nil
+		}
+
+		run, err := activeRun(tx, experiment.ID)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return fmt.Errorf("failed to find active run: %w", err)
+		}
+
+		nodeStatus := models.ExperimentNodeStatusCompleted
+		if status != models.ExperimentStatusCompleted {
+			nodeStatus = models.ExperimentNodeStatusFailed
+		}
+
+		halt, err = h.haltExperiment(tx, &experiment, nodeStatus)
+		if err != nil {
+			return err
+		}
+
+		if err := transitionExperiment(tx, &experiment, status, systemActor, reason); err != nil {
+			return err
+		}
+
+		if run == nil {
+			return nil
+		}
+
+		summary := ""
+		if run.FlwrLog != "" {
+			if summary, err = utils.ReadRunSummary(run.FlwrLog); err != nil {
+				log.Printf("Failed to read summary of run %d: %v", run.ID, err)
+			}
+		}
+
+		return tx.Model(run).Updates(map[string]interface{}{
+			"exit_code": exitCode,
+			"summary":   summary,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.completeHalt(halt)
+}
+
+// handleNodeFailures marks the active experiment nodes of the given nodes as
+// failed. Every affected experiment keeps running while it has at least the
+// configured minimum of active nodes and is stopped otherwise.
+func (h *ExperimentHandler) handleNodeFailures(nodeIDs []uint) error {
+	experimentMutex.Lock()
+	defer experimentMutex.Unlock()
+
+	var failedNodes []models.ExperimentNode
+	if err := h.DB.Joins("JOIN experiments ON experiments.id = experiment_nodes.experiment_id").
+		Where("experiment_nodes.node_id IN ? AND experiment_nodes.status IN ?", nodeIDs, activeNodeStatuses).
+		Where("experiments.status IN ?", models.ActiveExperimentStatuses).
+		Find(&failedNodes).Error; err != nil {
+		return fmt.Errorf("failed to fetch experiment nodes: %w", err)
+	}
+
+	affected := make(map[uint]bool)
+	for _, en := range failedNodes {
+		if err := h.DB.Model(&models.ExperimentNode{}).
+			Where("experiment_id = ? AND node_id = ? AND metadata_id = ?", en.ExperimentID, en.NodeID, en.MetadataID).
+			Update("status", models.ExperimentNodeStatusFailed).Error; err != nil {
+			return fmt.Errorf("failed to mark experiment node as failed: %w", err)
+		}
+		affected[en.ExperimentID] = true
+	}
+
+	for experimentID := range affected {
+		if err := h.recoverExperiment(experimentID); err != nil {
+			log.Printf("Failed to recover experiment %d after node failure: %v", experimentID, err)
+		}
+	}
+
+	return nil
+}
+
+func (h *ExperimentHandler) recoverExperiment(experimentID uint) error {
+	var remaining int64
+	if err := h.DB.Model(&models.ExperimentNode{}).
+		Where("experiment_id = ? AND status IN ?", experimentID, activeNodeStatuses).
+		Count(&remaining).Error; err != nil {
+		return fmt.Errorf("failed to count active nodes: %w", err)
+	}
+
+	if remaining >= int64(h.Config.Nodes.MinActiveNodes) {
+		log.Printf("Experiment %d continues with %d active nodes", experimentID, remaining)
+		_, err := h.startIfNodesReady(experimentID)
+		return err
+	}
+
+	log.Printf("Experiment %d has %d active nodes left, stopping it", experimentID, remaining)
+
+	var halt *experimentHalt
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var experiment models.Experiment
+		if err := tx.First(&experiment, experimentID).Error; err != nil {
+			return fmt.Errorf("failed to find experiment: %w", err)
+		}
+
+		var err error
+		halt, err = h.haltExperiment(tx, &experiment, models.ExperimentNodeStatusStopped)
+		if err != nil {
+			return err
+		}
+
+		reason := fmt.Sprintf("only %d active nodes left after node failures", remaining)
+		return transitionExperiment(tx, &experiment, models.ExperimentStatusFailed, systemActor, reason)
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.completeHalt(halt)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"link/internal/models"
+	"link/internal/utils"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "link.db")), &gorm.Config{
+		Logger:                                   logger.Discard,
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Node{}, &models.Metadata{}, &models.Experiment{}, &models.ExperimentNode{}, &models.ExperimentStatusTransition{}, &models.Run{}, &models.RunNode{}, &models.Upload{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func mustCreate(t *testing.T, db *gorm.DB, value interface{}) {
+	t.Helper()
+	if err := db.Create(value).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func transitionHistory(t *testing.T, db *gorm.DB, experimentID uint) []models.ExperimentStatusTransition {
+	t.Helper()
+	var history []models.ExperimentStatusTransition
+	if err := db.Where("experiment_id = ?", experimentID).Order("id").Find(&history).Error; err != nil {
+		t.Fatal(err)
+	}
+	return history
+}
+
+func TestTransitionExperiment(t *testing.T) {
+	db := newTestDB(t)
+	experiment := models.Experiment{Name: "exp", Status: models.ExperimentStatusDraft}
+	mustCreate(t, db, &experiment)
+
+	steps := []models.ExperimentStatus{
+		models.ExperimentStatusAwaitingNodes,
+		models.ExperimentStatusReady,
+		models.ExperimentStatusPreparing,
+		models.ExperimentStatusReady,
+	}
+	for _, next := range steps {
+		if err := transitionExperiment(db, &experiment, next, "user:1", "test"); err != nil {
+			t.Fatalf("transitionExperiment(%s) error = %v", next, err)
+		}
+	}
+
+	// Moving to the current status records nothing
+	if err := transitionExperiment(db, &experiment, models.ExperimentStatusReady, "user:1", "test"); err != nil {
+		t.Fatalf("transitionExperiment() to the current status error = %v", err)
+	}
+
+	err := transitionExperiment(db, &experiment, models.ExperimentStatusCompleted, "user:1", "test")
+	var appErr *utils.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode != 400 {
+		t.Fatalf("transitionExperiment(READY -> COMPLETED) error = %v, want a 400", err)
+	}
+
+	var stored models.Experiment
+	if err := db.First(&stored, experiment.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.ExperimentStatusReady || experiment.Status != models.ExperimentStatusReady {
+		t.Fatalf("status = %s (stored %s), want READY", experiment.Status, stored.Status)
+	}
+
+	history := transitionHistory(t, db, experiment.ID)
+	if len(history) != len(steps) {
+		t.Fatalf("history has %d transitions, want %d", len(history), len(steps))
+	}
+	from := models.ExperimentStatusDraft
+	for i, transition := range history {
+		if transition.FromStatus != from || transition.ToStatus != steps[i] || transition.Actor != "user:1" || transition.Reason != "test" {
+			t.Fatalf("transition %d = %+v", i, transition)
+		}
+		from = transition.ToStatus
+	}
+}
+
+func TestTransitionExperimentSyncsActiveRun(t *testing.T) {
+	db := newTestDB(t)
+	experiment := models.Experiment{Name: "exp", Status: models.ExperimentStatusPreparing}
+	mustCreate(t, db, &experiment)
+	run := models.Run{ExperimentID: experiment.ID, Number: 1, Status: models.ExperimentStatusPreparing}
+	mustCreate(t, db, &run)
+	mustCreate(t, db, &models.RunNode{RunID: run.ID, NodeID: 1, MetadataID: 1, Status: models.ExperimentNodeStatusAccepted})
+	mustCreate(t, db, &models.ExperimentNode{ExperimentID: experiment.ID, NodeID: 1, MetadataID: 1, Status: models.ExperimentNodeStatusPreparing})
+
+	if err := transitionExperiment(db, &experiment, models.ExperimentStatusTraining, systemActor, "started"); err != nil {
+		t.Fatalf("transitionExperiment(TRAINING) error = %v", err)
+	}
+	if err := db.First(&run, run.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != models.ExperimentStatusTraining || run.StartedAt == nil || run.FinishedAt != nil {
+		t.Fatalf("run after TRAINING = %+v", run)
+	}
+
+	if err := db.Model(&models.ExperimentNode{}).Where("experiment_id = ?", experiment.ID).
+		Update("status", models.ExperimentNodeStatusCompleted).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := transitionExperiment(db, &experiment, models.ExperimentStatusCompleted, systemActor, "flwr run exited with code 0"); err != nil {
+		t.Fatalf("transitionExperiment(COMPLETED) error = %v", err)
+	}
+	if err := db.First(&run, run.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != models.ExperimentStatusCompleted || run.FinishedAt == nil {
+		t.Fatalf("run after COMPLETED = %+v", run)
+	}
+
+	// The run keeps the final status of its nodes
+	var runNode models.RunNode
+	if err := db.Where("run_id = ?", run.ID).First(&runNode).Error; err != nil {
+		t.Fatal(err)
+	}
+	if runNode.Status != models.ExperimentNodeStatusCompleted {
+		t.Fatalf("run node status = %s, want COMPLETED", runNode.Status)
+	}
+
+	// A new start leaves the finished run alone
+	if err := transitionExperiment(db, &experiment, models.ExperimentStatusPreparing, systemActor, "started again"); err != nil {
+		t.Fatalf("transitionExperiment(PREPARING) error = %v", err)
+	}
+	if err := db.First(&run, run.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != models.ExperimentStatusCompleted {
+		t.Fatalf("finished run status = %s, want COMPLETED", run.Status)
+	}
+}
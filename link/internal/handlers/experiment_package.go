@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"link/internal/models"
+	"link/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// packageReport describes an uploaded Flower app and every problem found in it
+type packageReport struct {
+	Valid        bool                   `json:"valid"`
+	Problems     []utils.FieldError     `json:"problems"`
+	AppFolder    string                 `json:"app_folder,omitempty"`
+	ProjectName  string                 `json:"project_name,omitempty"`
+	Components   map[string]string      `json:"components,omitempty"`
+	RunConfig    map[string]interface{} `json:"run_config,omitempty"`
+	Dependencies []string               `json:"dependencies,omitempty"`
+}
+
+func (r *packageReport) addProblem(field, message string) {
+	r.Problems = append(r.Problems, utils.FieldError{Field: field, Message: message})
+}
+
+// experimentArchive is the archive of an experiment package, sent either as
+// the experimentFiles form file or as the upload_id of a completed chunked upload
+type experimentArchive struct {
+	file interface {
+		utils.ArchiveSource
+		io.Closer
+	}
+	size   int64
+	upload *models.Upload
+}
+
+func openExperimentArchive(c echo.Context, db *gorm.DB) (*experimentArchive, error) {
+	if c.FormValue("upload_id") != "" {
+		upload, err := findCompletedUpload(c, db)
+		if err != nil {
+			return nil, err
+		}
+		return openUploadArchive(upload)
+	}
+
+	header, err := c.FormFile("experimentFiles")
+	if err != nil {
+		return nil, utils.NewBadRequestError("Failed to get experiment files")
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, utils.NewInternalServerError("Failed to open experiment files")
+	}
+	return &experimentArchive{file: file, size: header.Size}, nil
+}
+
+// findCompletedUpload loads the completed chunked upload of the calling user
+// named by the upload_id form value
+func findCompletedUpload(c echo.Context, db *gorm.DB) (*models.Upload, error) {
+	userID, ok := c.Get("user_id").(float64)
+	if !ok {
+		return nil, utils.NewUnauthorizedError("Only users can upload experiments")
+	}
+
+	var upload models.Upload
+	if err := db.Where("id = ? AND user_id = ?", c.FormValue("upload_id"), uint(userID)).First(&upload).Error; err != nil {
+		return nil, utils.NewNotFoundError("Upload not found")
+	}
+	if upload.Status != models.UploadStatusCompleted {
+		return nil, utils.NewBadRequestError("Upload is not complete")
+	}
+	return &upload, nil
+}
+
+func openUploadArchive(upload *models.Upload) (*experimentArchive, error) {
+	file, err := os.Open(upload.Path)
+	if err != nil {
+		return nil, utils.NewInternalServerError("Failed to open upload file")
+	}
+	return &experimentArchive{file: file, size: upload.Size, upload: upload}, nil
+}
+
+func (a *experimentArchive) extract(dir string, limits utils.ArchiveLimits) error {
+	return utils.ExtractArchive(a.file, a.size, dir, limits)
+}
+
+// ValidateExperiment runs every check of CreateExperiment on the uploaded
+// package in a temporary directory without creating the experiment
+func (h *ExperimentHandler) ValidateExperiment(c echo.Context) error {
+	if err := c.Request().ParseMultipartForm(50 << 20); err != nil { // 50 MB max
+		return utils.NewBadRequestError("Failed to parse form data")
+	}
+
+	archive, err := openExperimentArchive(c, h.DB)
+	if err != nil {
+		return err
+	}
+	defer archive.file.Close()
+
+	tempDir, err := os.MkdirTemp("", "icfl-validate-")
+	if err != nil {
+		return utils.NewInternalServerError("Failed to create temporary directory")
+	}
+	defer os.RemoveAll(tempDir)
+
+	report := &packageReport{Problems: []utils.FieldError{}}
+	if err := archive.extract(tempDir, h.archiveLimits()); err != nil {
+		if !utils.IsArchiveError(err) {
+			return utils.NewInternalServerError(fmt.Sprintf("Failed to extract archive: %v", err))
+		}
+		report.addProblem("experimentFiles", err.Error())
+	} else {
+		report = inspectPackage(tempDir)
+	}
+
+	report.Valid = len(report.Problems) == 0
+	return c.JSON(200, report)
+}
+
+// inspectPackage checks the layout and pyproject.toml of a Flower app
+// extracted into dir and collects every problem instead of stopping at the
+// first one
+func inspectPackage(dir string) *packageReport {
+	report := &packageReport{Problems: []utils.FieldError{}}
+
+	// Find the experiment name folder
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		report.addProblem("experimentFiles", "failed to read the extracted archive")
+		return report
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			report.AppFolder = entry.Name()
+			break
+		}
+	}
+	if report.AppFolder == "" {
+		report.addProblem("experimentFiles", "missing experiment folder")
+		return report
+	}
+
+	appDir := filepath.Join(dir, report.AppFolder)
+	if _, err := os.Stat(filepath.Join(appDir, report.AppFolder)); os.IsNotExist(err) {
+		report.addProblem(report.AppFolder, "missing inner folder")
+	}
+
+	requiredFiles := []string{
+		filepath.Join(report.AppFolder, "pyproject.toml"),
+		filepath.Join(report.AppFolder, report.AppFolder, "client_app.py"),
+		filepath.Join(report.AppFolder, report.AppFolder, "server_app.py"),
+	}
+	for _, file := range requiredFiles {
+		if _, err := os.Stat(filepath.Join(dir, file)); os.IsNotExist(err) {
+			report.addProblem(filepath.ToSlash(file), "missing required file")
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(appDir, "pyproject.toml"))
+	if err != nil {
+		return report
+	}
+	pyproject, err := utils.ParsePyProject(data)
+	if err != nil {
+		report.addProblem("pyproject.toml", err.Error())
+		return report
+	}
+
+	report.ProjectName = pyproject.Project.Name
+	report.Components = map[string]string{
+		"serverapp": pyproject.Tool.Flwr.App.Components.ServerApp,
+		"clientapp": pyproject.Tool.Flwr.App.Components.ClientApp,
+	}
+	report.RunConfig = pyproject.Tool.Flwr.App.Config
+	report.Dependencies = pyproject.Project.Dependencies
+	report.Problems = append(report.Problems, pyproject.Validate(appDir, report.AppFolder)...)
+
+	return report
+}
+
+var packageContentTypes = map[string]string{
+	"zip":    "application/zip",
+	"tar.gz": "application/gzip",
+}
+
+// DownloadPackage streams the experiment's current revision, or the one given
+// as ?revision=, as a single .zip or .tar.gz archive (?format=). The archives
+// are deterministic, so the manifest hash serves as ETag and interrupted
+// downloads can be resumed with Range requests.
+func (h *ExperimentHandler) DownloadPackage(c echo.Context) error {
+	experimentID, err := parseExperimentID(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	experiment, err := authorizeExperimentAccess(c, h.DB, experimentID)
+	if err != nil {
+		return err
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "zip"
+	}
+	contentType, ok := packageContentTypes[format]
+	if !ok {
+		return utils.NewBadRequestError("format must be zip or tar.gz")
+	}
+
+	var revision *models.ExperimentRevision
+	if number := c.QueryParam("revision"); number != "" {
+		revision = &models.ExperimentRevision{}
+		if err := h.DB.Where("experiment_id = ? AND number = ?", experiment.ID, number).First(revision).Error; err != nil {
+			return utils.NewNotFoundError("Revision not found")
+		}
+	} else if revision, err = h.currentRevision(h.DB, experiment); err != nil {
+		return err
+	}
+
+	archivePath, err := buildPackageArchive(revision, format)
+	if err != nil {
+		log.Printf("Failed to build package of experiment %d revision %d: %v", experiment.ID, revision.Number, err)
+		return utils.NewInternalServerError("Failed to build experiment package")
+	}
+
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return utils.NewInternalServerError("Failed to open experiment package")
+	}
+	defer archive.Close()
+
+	appFolder := filepath.Base(revision.BasePath)
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-r%d.%s", appFolder, revision.Number, format)))
+	header.Set("ETag", fmt.Sprintf("\"%s.%s\"", revision.ManifestHash, strings.ReplaceAll(format, ".", "")))
+
+	// ServeContent answers If-None-Match, If-Range and Range requests
+	http.ServeContent(c.Response(), c.Request(), "", time.Time{}, archive)
+	return nil
+}
+
+// buildPackageArchive returns the cached archive of a revision, building it
+// below uploads/<id>/packages on first use
+func buildPackageArchive(revision *models.ExperimentRevision, format string) (string, error) {
+	packagesDir := filepath.Join("uploads", fmt.Sprintf("%d", revision.ExperimentID), "packages")
+	archivePath := filepath.Join(packagesDir, revision.ManifestHash+"."+format)
+	if _, err := os.Stat(archivePath); err == nil {
+		return archivePath, nil
+	}
+
+	if err := os.MkdirAll(packagesDir, 0755); err != nil {
+		return "", err
+	}
+	tempFile, err := os.CreateTemp(packagesDir, ".package-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tempFile.Name())
+
+	appFolder := filepath.Base(revision.BasePath)
+	if err := utils.WritePackageArchive(tempFile, revision.BasePath, appFolder, revision.Manifest, format); err != nil {
+		tempFile.Close()
+		return "", err
+	}
+	if err := tempFile.Close(); err != nil {
+		return "", err
+	}
+
+	// Concurrent builds produce identical bytes, so the last rename wins harmlessly
+	if err := os.Rename(tempFile.Name(), archivePath); err != nil {
+		return "", err
+	}
+	return archivePath, nil
+}
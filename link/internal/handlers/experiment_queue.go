@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"link/internal/models"
+	"link/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// maxQueuePriority bounds the priority admins may give a start request
+const maxQueuePriority = 100
+
+type queuedExperiment struct {
+	models.ExperimentQueueEntry
+	Position       int        `json:"position"`
+	EstimatedStart *time.Time `json:"estimated_start"`
+}
+
+// enqueueExperiment adds a start request for the experiment to the queue. The
+// caller must hold experimentMutex.
+func (h *ExperimentHandler) enqueueExperiment(c echo.Context, experimentID uint, userID uint, priority int, runConfig map[string]interface{}) error {
+	var experiment models.Experiment
+	if err := h.DB.First(&experiment, experimentID).Error; err != nil {
+		return utils.NewNotFoundError("Experiment not found")
+	}
+
+	if experiment.Status.IsActive() {
+		return utils.NewBadRequestError("Experiment is already in progress")
+	}
+
+	if !experiment.Status.CanTransitionTo(models.ExperimentStatusPreparing) {
+		return utils.NewBadRequestError(fmt.Sprintf("Experiment cannot be started while it is %s", experiment.Status))
+	}
+
+	// Reject bad overrides now, they are checked again when the entry starts
+	if _, _, err := resolveRunConfig(&experiment, runConfig); err != nil {
+		return err
+	}
+
+	var existing int64
+	if err := h.DB.Model(&models.ExperimentQueueEntry{}).
+		Where("experiment_id = ? AND status = ?", experiment.ID, models.QueueEntryStatusQueued).
+		Count(&existing).Error; err != nil {
+		return utils.NewInternalServerError("Failed to check experiment queue")
+	}
+
+	if existing > 0 {
+		return utils.NewBadRequestError("Experiment is already queued")
+	}
+
+	entry := models.ExperimentQueueEntry{
+		ExperimentID: experiment.ID,
+		UserID:       userID,
+		Priority:     priority,
+		RunConfig:    runConfig,
+		Status:       models.QueueEntryStatusQueued,
+	}
+	if err := h.DB.Create(&entry).Error; err != nil {
+		return utils.NewInternalServerError("Failed to queue experiment")
+	}
+
+	queue, err := h.loadQueue()
+	if err != nil {
+		return utils.NewInternalServerError("Failed to fetch experiment queue")
+	}
+
+	for _, queued := range queue {
+		if queued.ID == entry.ID {
+			return c.JSON(202, queued)
+		}
+	}
+
+	return c.JSON(202, queuedExperiment{ExperimentQueueEntry: entry})
+}
+
+func (h *ExperimentHandler) ListQueue(c echo.Context) error {
+	queue, err := h.loadQueue()
+	if err != nil {
+		return utils.NewInternalServerError("Failed to fetch experiment queue")
+	}
+
+	return c.JSON(200, queue)
+}
+
+// CancelQueueEntry cancels a waiting start request. Only the user who made the
+// request and admins may cancel it.
+func (h *ExperimentHandler) CancelQueueEntry(c echo.Context) error {
+	user, err := requestUser(c, h.DB)
+	if err != nil {
+		return err
+	}
+
+	var entry models.ExperimentQueueEntry
+	if err := h.DB.Where("id = ? AND status = ?", c.Param("entryID"), models.QueueEntryStatusQueued).First(&entry).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.NewNotFoundError("Queued experiment not found")
+		}
+		return utils.NewInternalServerError("Failed to fetch queue entry")
+	}
+
+	if entry.UserID != user.ID && !user.Admin {
+		return utils.NewForbiddenError("Only the user who queued the experiment or an admin can cancel it")
+	}
+
+	result := h.DB.Model(&entry).
+		Where("status = ?", models.QueueEntryStatusQueued).
+		Update("status", models.QueueEntryStatusCancelled)
+	if result.Error != nil {
+		return utils.NewInternalServerError("Failed to cancel queue entry")
+	}
+
+	if result.RowsAffected == 0 {
+		return utils.NewNotFoundError("Queued experiment not found")
+	}
+
+	return c.NoContent(204)
+}
+
+// checkQueuePriority only lets admins move a start request ahead of others
+func checkQueuePriority(c echo.Context, db *gorm.DB, priority int) error {
+	if priority == 0 {
+		return nil
+	}
+	if priority < 0 || priority > maxQueuePriority {
+		return utils.NewBadRequestError(fmt.Sprintf("Priority must be between 0 and %d", maxQueuePriority))
+	}
+
+	user, err := requestUser(c, db)
+	if err != nil {
+		return err
+	}
+	if !user.Admin {
+		return utils.NewForbiddenError("Only admins can set a priority")
+	}
+	return nil
+}
+
+// loadQueue returns the waiting start requests in the order they will be
+// started, with their position and an estimated start time
+func (h *ExperimentHandler) loadQueue() ([]queuedExperiment, error) {
+	var entries []models.ExperimentQueueEntry
+	if err := h.DB.Preload("Experiment").
+		Where("status = ?", models.QueueEntryStatusQueued).
+		Order("priority DESC, id ASC").
+		Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	estimates, err := h.estimateStarts(len(entries))
+	if err != nil {
+		log.Printf("Failed to estimate queue start times: %v", err)
+	}
+
+	queue := make([]queuedExperiment, len(entries))
+	for i, entry := range entries {
+		queue[i] = queuedExperiment{ExperimentQueueEntry: entry, Position: i + 1}
+		if estimates != nil {
+			queue[i].EstimatedStart = &estimates[i]
+		}
+	}
+
+	return queue, nil
+}
+
+// estimateStarts predicts when the next n queued experiments will start, based
+// on the average duration of recently finished runs. It returns nil when
+// there is no history to base the estimate on.
+func (h *ExperimentHandler) estimateStarts(n int) ([]time.Time, error) {
+	if n == 0 {
+		return nil, nil
+	}
+
+	var finished []models.Run
+	if err := h.DB.Select("started_at", "finished_at").
+		Where("started_at IS NOT NULL AND finished_at IS NOT NULL").
+		Order("finished_at DESC").
+		Limit(20).
+		Find(&finished).Error; err != nil {
+		return nil, err
+	}
+
+	if len(finished) == 0 {
+		return nil, nil
+	}
+
+	var total time.Duration
+	for _, run := range finished {
+		total += run.FinishedAt.Sub(*run.StartedAt)
+	}
+	average := total / time.Duration(len(finished))
+
+	var running []models.Run
+	if err := h.DB.Select("started_at").
+		Where("status IN ?", models.ActiveExperimentStatuses).
+		Find(&running).Error; err != nil {
+		return nil, err
+	}
+
+	// Time from now until each federation slot becomes free
+	now := time.Now()
+	slots := make([]time.Duration, 0, h.Config.Federation.MaxConcurrentExperiments)
+	for _, run := range running {
+		remaining := average
+		if run.StartedAt != nil {
+			remaining -= now.Sub(*run.StartedAt)
+		}
+		if remaining < 0 {
+			remaining = 0
+		}
+		slots = append(slots, remaining)
+	}
+	for len(slots) < h.Config.Federation.MaxConcurrentExperiments {
+		slots = append(slots, 0)
+	}
+
+	estimates := make([]time.Time, n)
+	for i := range estimates {
+		sort.Slice(slots, func(a, b int) bool { return slots[a] < slots[b] })
+		estimates[i] = now.Add(slots[0])
+		slots[0] += average
+	}
+
+	return estimates, nil
+}
+
+// scheduleQueueDrain starts queued experiments in the background once the
+// current holder of experimentMutex releases it
+func (h *ExperimentHandler) scheduleQueueDrain() {
+	go h.DrainQueue()
+}
+
+// DrainQueue starts queued experiments while federation slots are free
+func (h *ExperimentHandler) DrainQueue() {
+	experimentMutex.Lock()
+	defer experimentMutex.Unlock()
+
+	for h.PythonEnv.HasFreeFederationSlot() {
+		var entry models.ExperimentQueueEntry
+		err := h.DB.Where("status = ?", models.QueueEntryStatusQueued).
+			Order("priority DESC, id ASC").
+			First(&entry).Error
+		if err == gorm.ErrRecordNotFound {
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to fetch experiment queue: %v", err)
+			return
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{
+			"status":     models.QueueEntryStatusStarted,
+			"started_at": now,
+		}
+
+		if _, err := h.startExperiment(entry.ExperimentID, entry.RunConfig, fmt.Sprintf("user:%d", entry.UserID), "started from the queue"); err != nil {
+			log.Printf("Failed to start queued experiment %d: %v", entry.ExperimentID, err)
+			updates = map[string]interface{}{
+				"status": models.QueueEntryStatusFailed,
+				"error":  err.Error(),
+			}
+		} else {
+			log.Printf("Started queued experiment %d", entry.ExperimentID)
+		}
+
+		if err := h.DB.Model(&entry).Updates(updates).Error; err != nil {
+			log.Printf("Failed to update queue entry %d: %v", entry.ID, err)
+			return
+		}
+	}
+}
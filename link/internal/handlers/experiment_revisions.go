@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"link/internal/models"
+	"link/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// newStagingDir creates an empty directory below uploads/.staging. Staging
+// below uploads keeps the final rename on the same file system.
+func newStagingDir() (string, error) {
+	stagingRoot := filepath.Join("uploads", ".staging")
+	if err := os.MkdirAll(stagingRoot, 0755); err != nil {
+		return "", err
+	}
+	stagingDir, err := os.MkdirTemp(stagingRoot, "experiment-")
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(stagingDir, 0755); err != nil {
+		os.RemoveAll(stagingDir)
+		return "", err
+	}
+	return stagingDir, nil
+}
+
+// createRevision records the package staged in stagingDir as the next
+// revision of the experiment and moves it to uploads/<id>/revisions/<n>. The
+// move is the last step so the caller's transaction can still be rolled back
+// when it fails. It returns nil when the files equal the current revision.
+func (h *ExperimentHandler) createRevision(tx *gorm.DB, experiment *models.Experiment, stagingDir, appFolder, actor string) (*models.ExperimentRevision, error) {
+	manifest, err := utils.BuildManifest(filepath.Join(stagingDir, appFolder))
+	if err != nil {
+		return nil, utils.NewInternalServerError(fmt.Sprintf("Failed to hash experiment files: %v", err))
+	}
+	manifestHash := utils.ManifestHash(manifest)
+
+	var current models.ExperimentRevision
+	err = tx.Where("experiment_id = ? AND number = ?", experiment.ID, experiment.Revision).First(&current).Error
+	if err == nil && current.ManifestHash == manifestHash {
+		return nil, nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.NewInternalServerError("Failed to fetch the current revision")
+	}
+
+	var lastNumber int
+	if err := tx.Model(&models.ExperimentRevision{}).
+		Where("experiment_id = ?", experiment.ID).
+		Select("COALESCE(MAX(number), 0)").
+		Scan(&lastNumber).Error; err != nil {
+		return nil, utils.NewInternalServerError("Failed to number the revision")
+	}
+
+	signature, err := h.signManifest(experiment.ID, lastNumber+1, manifestHash)
+	if err != nil {
+		return nil, err
+	}
+
+	revisionDir := filepath.Join("uploads", fmt.Sprintf("%d", experiment.ID), "revisions", fmt.Sprintf("%d", lastNumber+1))
+	revision := models.ExperimentRevision{
+		ExperimentID: experiment.ID,
+		Number:       lastNumber + 1,
+		BasePath:     revisionDir + "/" + appFolder,
+		ManifestHash: manifestHash,
+		Manifest:     manifest,
+		Signature:    signature,
+		Actor:        actor,
+	}
+	if err := tx.Create(&revision).Error; err != nil {
+		return nil, utils.NewInternalServerError("Failed to record the revision")
+	}
+
+	experiment.BasePath = revision.BasePath
+	experiment.Revision = revision.Number
+	if err := tx.Model(experiment).Updates(map[string]interface{}{
+		"base_path": experiment.BasePath,
+		"revision":  experiment.Revision,
+	}).Error; err != nil {
+		return nil, utils.NewInternalServerError("Failed to update experiment with file path")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(revisionDir), 0755); err != nil {
+		return nil, utils.NewInternalServerError("Failed to create revisions directory")
+	}
+	if err := os.Rename(stagingDir, revisionDir); err != nil {
+		log.Printf("Error moving revision %d of experiment %d into place: %v\n", revision.Number, experiment.ID, err)
+		return nil, utils.NewInternalServerError("Failed to store experiment files")
+	}
+
+	return &revision, nil
+}
+
+func (h *ExperimentHandler) signManifest(experimentID uint, revision int, manifestHash string) (string, error) {
+	signature, err := h.Signer.SignManifest(experimentID, revision, manifestHash)
+	if err != nil {
+		return "", utils.NewInternalServerError(fmt.Sprintf("Failed to sign the experiment manifest: %v", err))
+	}
+	return signature, nil
+}
+
+// discardRevision removes the files of a revision whose transaction failed
+func discardRevision(revision *models.ExperimentRevision) {
+	if revision == nil {
+		return
+	}
+	if err := os.RemoveAll(filepath.Dir(revision.BasePath)); err != nil {
+		log.Printf("Failed to remove files of discarded revision %d of experiment %d: %v", revision.Number, revision.ExperimentID, err)
+	}
+}
+
+// currentRevision returns the experiment's current revision. Experiments
+// created before revisions existed get an unsaved revision 0 hashed and
+// signed from the files on disk.
+func (h *ExperimentHandler) currentRevision(tx *gorm.DB, experiment *models.Experiment) (*models.ExperimentRevision, error) {
+	var revision models.ExperimentRevision
+	err := tx.Where("experiment_id = ? AND number = ?", experiment.ID, experiment.Revision).First(&revision).Error
+	if err == nil {
+		return &revision, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, utils.NewInternalServerError("Failed to fetch the current revision")
+	}
+
+	manifest, err := utils.BuildManifest(experiment.BasePath)
+	if err != nil {
+		return nil, utils.NewInternalServerError(fmt.Sprintf("Failed to hash experiment files: %v", err))
+	}
+	revision = models.ExperimentRevision{
+		ExperimentID: experiment.ID,
+		BasePath:     experiment.BasePath,
+		ManifestHash: utils.ManifestHash(manifest),
+		Manifest:     manifest,
+	}
+	if revision.Signature, err = h.signManifest(experiment.ID, 0, revision.ManifestHash); err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// runBasePath returns the app folder of the revision a run was started with
+func runBasePath(tx *gorm.DB, experiment *models.Experiment, run *models.Run) (string, error) {
+	if run.Revision == 0 || run.Revision == experiment.Revision {
+		return experiment.BasePath, nil
+	}
+
+	var revision models.ExperimentRevision
+	if err := tx.Where("experiment_id = ? AND number = ?", experiment.ID, run.Revision).First(&revision).Error; err != nil {
+		return "", fmt.Errorf("failed to find revision %d of experiment %d: %w", run.Revision, experiment.ID, err)
+	}
+	return revision.BasePath, nil
+}
+
+func (h *ExperimentHandler) ListRevisions(c echo.Context) error {
+	experimentID, err := parseExperimentID(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	experiment, err := authorizeExperimentAccess(c, h.DB, experimentID)
+	if err != nil {
+		return err
+	}
+
+	var revisions []models.ExperimentRevision
+	if err := h.DB.Omit("Manifest").
+		Where("experiment_id = ?", experiment.ID).
+		Order("number DESC").
+		Find(&revisions).Error; err != nil {
+		return utils.NewInternalServerError("Failed to fetch revisions")
+	}
+
+	return c.JSON(200, revisions)
+}
+
+func (h *ExperimentHandler) GetRevision(c echo.Context) error {
+	experimentID, err := parseExperimentID(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	experiment, err := authorizeExperimentAccess(c, h.DB, experimentID)
+	if err != nil {
+		return err
+	}
+
+	var revision models.ExperimentRevision
+	if err := h.DB.Where("experiment_id = ? AND number = ?", experiment.ID, c.Param("number")).
+		First(&revision).Error; err != nil {
+		return utils.NewNotFoundError("Revision not found")
+	}
+
+	return c.JSON(200, revision)
+}
+
+// DiffRevisions lists the files added, removed and modified between the
+// revisions from and to. They default to the current revision and the one
+// before it.
+func (h *ExperimentHandler) DiffRevisions(c echo.Context) error {
+	experimentID, err := parseExperimentID(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	experiment, err := authorizeExperimentAccess(c, h.DB, experimentID)
+	if err != nil {
+		return err
+	}
+
+	to, err := revisionNumberParam(c, "to", experiment.Revision)
+	if err != nil {
+		return err
+	}
+	from, err := revisionNumberParam(c, "from", to-1)
+	if err != nil {
+		return err
+	}
+
+	var fromManifest []models.ManifestEntry
+	if from > 0 {
+		var fromRevision models.ExperimentRevision
+		if err := h.DB.Where("experiment_id = ? AND number = ?", experiment.ID, from).First(&fromRevision).Error; err != nil {
+			return utils.NewNotFoundError(fmt.Sprintf("Revision %d not found", from))
+		}
+		fromManifest = fromRevision.Manifest
+	}
+
+	var toRevision models.ExperimentRevision
+	if err := h.DB.Where("experiment_id = ? AND number = ?", experiment.ID, to).First(&toRevision).Error; err != nil {
+		return utils.NewNotFoundError(fmt.Sprintf("Revision %d not found", to))
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"from": from,
+		"to":   to,
+		"diff": utils.DiffManifests(fromManifest, toRevision.Manifest),
+	})
+}
+
+func revisionNumberParam(c echo.Context, name string, fallback int) (int, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return fallback, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, utils.NewBadRequestError(fmt.Sprintf("Invalid %s revision", name))
+	}
+	return number, nil
+}
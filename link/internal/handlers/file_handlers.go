@@ -1,27 +1,113 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
+	"link/internal/models"
 	"link/internal/utils"
 
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
-type FileHandler struct{}
+type FileHandler struct {
+	DB *gorm.DB
+}
+
+// DownloadExperimentFile serves a file of the experiment's current revision,
+// or of the revision given as ?revision=, by its path relative to the app folder
+func (h *FileHandler) DownloadExperimentFile(c echo.Context) error {
+	experimentID, err := parseExperimentID(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	experiment, err := authorizeExperimentAccess(c, h.DB, experimentID)
+	if err != nil {
+		return err
+	}
 
+	root := experiment.BasePath
+	if number := c.QueryParam("revision"); number != "" {
+		var revision models.ExperimentRevision
+		if err := h.DB.Where("experiment_id = ? AND number = ?", experiment.ID, number).First(&revision).Error; err != nil {
+			return utils.NewNotFoundError("Revision not found")
+		}
+		root = revision.BasePath
+	}
+
+	return serveFileWithin(c, root, c.QueryParam("path"))
+}
+
+// DownloadFile serves a file of an experiment revision by its path below
+// uploads/<experiment id>/revisions, as sent in the files_path of experiment
+// instructions. Logs and package archives next to the revisions are not served.
 func (h *FileHandler) DownloadFile(c echo.Context) error {
-	path := c.QueryParam("path")
-	if path == "" {
+	requested := c.QueryParam("path")
+	if requested == "" {
+		return utils.NewBadRequestError("File path is required")
+	}
+
+	// Clean before checking the prefix so ../ cannot leave the uploads folder
+	cleaned := path.Clean("/" + strings.ReplaceAll(requested, "\\", "/"))
+	parts := strings.SplitN(strings.TrimPrefix(cleaned, "/"), "/", 3)
+	if len(parts) < 3 || parts[0] != "uploads" {
+		return utils.NewBadRequestError("Invalid file path")
+	}
+
+	experimentID, err := parseExperimentID(parts[1])
+	if err != nil {
+		return err
+	}
+	experiment, err := authorizeExperimentAccess(c, h.DB, experimentID)
+	if err != nil {
+		return err
+	}
+
+	experimentDir := filepath.Join("uploads", fmt.Sprintf("%d", experiment.ID))
+	if rel, ok := strings.CutPrefix(parts[2], "revisions/"); ok {
+		return serveFileWithin(c, filepath.Join(experimentDir, "revisions"), rel)
+	}
+
+	// Experiments created before revisions keep their files in their base path
+	appFolder, rel, _ := strings.Cut(parts[2], "/")
+	if filepath.Clean(experiment.BasePath) == filepath.Join(experimentDir, appFolder) && !reservedExperimentDirs[appFolder] {
+		return serveFileWithin(c, experiment.BasePath, rel)
+	}
+
+	return utils.NewNotFoundError("File not found")
+}
+
+// reservedExperimentDirs are the folders below uploads/<experiment id> that
+// never hold an app folder
+var reservedExperimentDirs = map[string]bool{
+	"revisions": true,
+	"logs":      true,
+	"packages":  true,
+}
+
+func serveFileWithin(c echo.Context, root, rel string) error {
+	if rel == "" {
 		return utils.NewBadRequestError("File path is required")
 	}
 
-	if !strings.HasPrefix(path, "uploads/") {
+	fullPath, err := utils.ResolveWithin(root, rel)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return utils.NewNotFoundError("File not found")
+		}
 		return utils.NewBadRequestError("Invalid file path")
 	}
 
-	fullPath := filepath.Clean(path)
+	info, err := os.Stat(fullPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return utils.NewNotFoundError("File not found")
+	}
 
 	return c.File(fullPath)
 }
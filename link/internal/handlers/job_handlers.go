@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"link/internal/jobs"
+	"link/internal/models"
+	"link/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type JobHandler struct {
+	DB     *gorm.DB
+	Runner *jobs.Runner
+}
+
+// GetJob returns a job with its logs. Nodes and users see the jobs of the
+// experiments they have access to; jobs without an experiment are only shown
+// to users.
+func (h *JobHandler) GetJob(c echo.Context) error {
+	job, err := h.findJob(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.DB.Where("job_id = ?", job.ID).Order("id").Find(&job.Logs).Error; err != nil {
+		return utils.NewInternalServerError("Failed to fetch job logs")
+	}
+
+	return c.JSON(200, job)
+}
+
+// ListJobs lists the most recent jobs of an experiment (?experiment_id=) or,
+// for users, of the whole link, optionally filtered by ?status=
+func (h *JobHandler) ListJobs(c echo.Context) error {
+	query := h.DB.Model(&models.Job{}).Order("id DESC").Limit(100)
+
+	if value := c.QueryParam("experiment_id"); value != "" {
+		experimentID, err := parseExperimentID(value)
+		if err != nil {
+			return err
+		}
+		if _, err := authorizeExperimentAccess(c, h.DB, experimentID); err != nil {
+			return err
+		}
+		query = query.Where("experiment_id = ?", experimentID)
+	} else if _, ok := c.Get("user_id").(float64); !ok {
+		return utils.NewBadRequestError("experiment_id is required")
+	}
+
+	if status := c.QueryParam("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var result []models.Job
+	if err := query.Find(&result).Error; err != nil {
+		return utils.NewInternalServerError("Failed to fetch jobs")
+	}
+
+	return c.JSON(200, result)
+}
+
+// RetryJob queues a failed job again. Only admins and the owner of the job's
+// experiment may retry it.
+func (h *JobHandler) RetryJob(c echo.Context) error {
+	user, err := requestUser(c, h.DB)
+	if err != nil {
+		return err
+	}
+
+	job, err := h.findJob(c)
+	if err != nil {
+		return err
+	}
+	if !user.Admin {
+		var experiment models.Experiment
+		if job.ExperimentID == nil || h.DB.Select("id", "user_id").First(&experiment, *job.ExperimentID).Error != nil || experiment.UserID != user.ID {
+			return utils.NewForbiddenError("Only the owner of the experiment or an admin can retry this job")
+		}
+	}
+	if job.Status != models.JobStatusFailed {
+		return utils.NewBadRequestError(fmt.Sprintf("Only failed jobs can be retried, this job is %s", job.Status))
+	}
+
+	job, err = h.Runner.Retry(job.ID)
+	if err != nil {
+		return utils.NewBadRequestError(err.Error())
+	}
+
+	return c.JSON(200, job)
+}
+
+func (h *JobHandler) findJob(c echo.Context) (*models.Job, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		return nil, utils.NewBadRequestError("Invalid job ID")
+	}
+
+	var job models.Job
+	if err := h.DB.First(&job, id).Error; err != nil {
+		return nil, utils.NewNotFoundError("Job not found")
+	}
+
+	if job.ExperimentID != nil {
+		if _, err := authorizeExperimentAccess(c, h.DB, *job.ExperimentID); err != nil {
+			return nil, utils.NewNotFoundError("Job not found")
+		}
+	} else if _, ok := c.Get("user_id").(float64); !ok {
+		return nil, utils.NewNotFoundError("Job not found")
+	}
+
+	return &job, nil
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"link/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+type KeyHandler struct {
+	Signer *utils.ManifestSigner
+}
+
+// GetManifestKey serves the public key that verifies the manifest signatures
+// sent with NEW_EXPERIMENT and UPDATE_EXPERIMENT instructions
+func (h *KeyHandler) GetManifestKey(c echo.Context) error {
+	publicKeyPEM, err := h.Signer.PublicKeyPEM()
+	if err != nil {
+		return utils.NewInternalServerError(err.Error())
+	}
+	publicKeySSH, fingerprint, err := h.Signer.PublicKeySSH()
+	if err != nil {
+		return utils.NewInternalServerError(err.Error())
+	}
+
+	return c.JSON(200, map[string]string{
+		"algorithm":      h.Signer.Algorithm(),
+		"public_key_pem": publicKeyPEM,
+		"public_key_ssh": publicKeySSH,
+		"fingerprint":    fingerprint,
+	})
+}
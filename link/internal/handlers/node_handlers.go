@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
 	"time"
 
+	"link/internal/config"
 	"link/internal/models"
 	"link/internal/store"
 	"link/internal/utils"
@@ -13,7 +18,8 @@ import (
 )
 
 type NodeHandler struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Config *config.Config
 }
 
 func (h *NodeHandler) RegisterNode(c echo.Context) error {
@@ -97,6 +103,7 @@ func (h *NodeHandler) UpdateNodeStatus(c echo.Context) error {
 	node := c.Get("node").(models.Node)
 
 	node.LastSeen = time.Now()
+	node.Online = true
 	if err := h.DB.Save(&node).Error; err != nil {
 		return utils.NewInternalServerError("Failed to update node status")
 	}
@@ -110,11 +117,165 @@ func (h *NodeHandler) ListNodes(c echo.Context) error {
 		return utils.NewInternalServerError("Failed to fetch nodes")
 	}
 
+	for i := range nodes {
+		nodes[i].Connected = store.GlobalPresence.IsConnected(nodes[i].ID)
+	}
+
 	return c.JSON(200, nodes)
 }
 
+// PollInstructions returns the instructions queued for the node. With the wait
+// query parameter (in seconds) the request is held open until an instruction
+// arrives or the wait runs out.
 func (h *NodeHandler) PollInstructions(c echo.Context) error {
 	node := c.Get("node").(models.Node)
-	instructions := store.GlobalInstructionStore.GetInstructions(node.ID)
-	return c.JSON(200, instructions)
+
+	wait, err := h.parseWait(c.QueryParam("wait"))
+	if err != nil {
+		return err
+	}
+
+	// Subscribe before the first read so no instruction added in between is missed
+	notifications, unsubscribe := store.GlobalInstructionStore.Subscribe(node.ID)
+	defer unsubscribe()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		instructions, err := store.GlobalInstructionStore.GetInstructions(node.ID)
+		if err != nil {
+			return utils.NewInternalServerError("Failed to fetch instructions")
+		}
+
+		if len(instructions) > 0 || wait == 0 {
+			return c.JSON(200, instructions)
+		}
+
+		select {
+		case <-notifications:
+		case <-timer.C:
+			return c.JSON(200, []models.Instruction{})
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+func (h *NodeHandler) parseWait(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, utils.NewBadRequestError("wait must be a non-negative number of seconds")
+	}
+
+	wait := time.Duration(seconds) * time.Second
+	if wait > h.Config.Nodes.LongPollMaxWait {
+		wait = h.Config.Nodes.LongPollMaxWait
+	}
+
+	return wait, nil
+}
+
+func (h *NodeHandler) AcknowledgeInstructions(c echo.Context) error {
+	node := c.Get("node").(models.Node)
+
+	var request struct {
+		IDs []uint `json:"ids"`
+	}
+	if err := c.Bind(&request); err != nil {
+		return utils.NewBadRequestError("Invalid request payload")
+	}
+
+	if len(request.IDs) == 0 {
+		return utils.NewBadRequestError("Instruction IDs are required")
+	}
+
+	acknowledged, err := store.GlobalInstructionStore.AcknowledgeInstructions(node.ID, request.IDs)
+	if err != nil {
+		return utils.NewInternalServerError("Failed to acknowledge instructions")
+	}
+
+	return c.JSON(200, map[string]interface{}{"acknowledged": acknowledged})
+}
+
+// StreamInstructions keeps a Server-Sent Events connection open with the node
+// and pushes its instructions as soon as they are queued. Heartbeat events are
+// sent periodically to keep the connection alive and track node presence.
+// Nodes that cannot hold a stream keep using PollInstructions.
+func (h *NodeHandler) StreamInstructions(c echo.Context) error {
+	node := c.Get("node").(models.Node)
+
+	notifications, unsubscribe := store.GlobalInstructionStore.Subscribe(node.ID)
+	defer unsubscribe()
+
+	disconnect := store.GlobalPresence.Connect(node.ID)
+	defer disconnect()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(200)
+	res.Flush()
+
+	heartbeat := time.NewTicker(h.Config.Nodes.HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	h.touchNode(node.ID)
+
+	for {
+		// Unacknowledged instructions are picked up again on every wake-up
+		instructions, err := store.GlobalInstructionStore.GetInstructions(node.ID)
+		if err != nil {
+			log.Printf("Failed to fetch instructions for node %d: %v", node.ID, err)
+		}
+		for _, instruction := range instructions {
+			if err := writeEvent(res, fmt.Sprintf("%d", instruction.ID), "instruction", instruction); err != nil {
+				return nil
+			}
+		}
+
+		select {
+		case <-notifications:
+		case <-heartbeat.C:
+			if err := writeEvent(res, "", "heartbeat", map[string]interface{}{"time": time.Now()}); err != nil {
+				return nil
+			}
+			store.GlobalPresence.Heartbeat(node.ID)
+			h.touchNode(node.ID)
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+func (h *NodeHandler) touchNode(nodeID uint) {
+	if err := h.DB.Model(&models.Node{}).Where("id = ?", nodeID).
+		Updates(map[string]interface{}{"last_seen": time.Now(), "online": true}).Error; err != nil {
+		log.Printf("Failed to update last seen for node %d: %v", nodeID, err)
+	}
+}
+
+func writeEvent(res *echo.Response, id, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if id != "" {
+		if _, err := fmt.Fprintf(res, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	res.Flush()
+
+	return nil
 }
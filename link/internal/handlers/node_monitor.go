@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"link/internal/models"
+)
+
+// MonitorNodeLiveness periodically marks nodes that have not been seen within
+// the configured offline timeout as offline and fails their running experiment
+// nodes. It returns when the context is cancelled.
+func (h *ExperimentHandler) MonitorNodeLiveness(ctx context.Context) {
+	ticker := time.NewTicker(h.Config.Nodes.LivenessCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.checkNodeLiveness(); err != nil {
+				log.Printf("Node liveness check failed: %v", err)
+			}
+		}
+	}
+}
+
+func (h *ExperimentHandler) checkNodeLiveness() error {
+	cutoff := time.Now().Add(-h.Config.Nodes.OfflineAfter)
+
+	var staleNodes []models.Node
+	if err := h.DB.Select("id", "username", "last_seen").
+		Where("online = ? AND last_seen < ?", true, cutoff).
+		Find(&staleNodes).Error; err != nil {
+		return err
+	}
+
+	if len(staleNodes) == 0 {
+		return nil
+	}
+
+	nodeIDs := make([]uint, len(staleNodes))
+	for i, node := range staleNodes {
+		nodeIDs[i] = node.ID
+		log.Printf("Node %s (%d) has not been seen since %s, marking it offline", node.Username, node.ID, node.LastSeen.Format(time.RFC3339))
+	}
+
+	if err := h.DB.Model(&models.Node{}).Where("id IN ?", nodeIDs).Update("online", false).Error; err != nil {
+		return err
+	}
+
+	return h.handleNodeFailures(nodeIDs)
+}
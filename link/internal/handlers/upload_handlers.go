@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"link/internal/config"
+	"link/internal/models"
+	"link/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var sha256Pattern = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// UploadHandler receives large experiment archives in parts. An upload is
+// started with its size and SHA-256, parts are appended at the current offset
+// until the file is complete, and the upload_id is then passed to
+// POST /api/experiments instead of the experimentFiles form file.
+type UploadHandler struct {
+	DB     *gorm.DB
+	Config *config.Config
+}
+
+func uploadsDir() string {
+	return filepath.Join("uploads", ".chunked")
+}
+
+func (h *UploadHandler) CreateUpload(c echo.Context) error {
+	userID, ok := c.Get("user_id").(float64)
+	if !ok {
+		return utils.NewUnauthorizedError("Only users can upload experiments")
+	}
+
+	var request struct {
+		FileName string `json:"file_name"`
+		Size     int64  `json:"size"`
+		SHA256   string `json:"sha256"`
+	}
+	if err := c.Bind(&request); err != nil {
+		return utils.NewBadRequestError("Invalid request payload")
+	}
+
+	var fields []utils.FieldError
+	if request.Size <= 0 {
+		fields = append(fields, utils.FieldError{Field: "size", Message: "must be greater than zero"})
+	} else if max := h.Config.Uploads.MaxUploadSize; max > 0 && request.Size > max {
+		fields = append(fields, utils.FieldError{Field: "size", Message: fmt.Sprintf("must not exceed %d bytes", max)})
+	}
+	if !sha256Pattern.MatchString(request.SHA256) {
+		fields = append(fields, utils.FieldError{Field: "sha256", Message: "must be a lowercase hex SHA-256 digest"})
+	}
+	if len(fields) > 0 {
+		return utils.NewValidationError("Invalid upload", fields)
+	}
+
+	if err := os.MkdirAll(uploadsDir(), 0755); err != nil {
+		return utils.NewInternalServerError("Failed to create uploads directory")
+	}
+
+	upload := models.Upload{
+		UserID:   uint(userID),
+		FileName: filepath.Base(request.FileName),
+		Size:     request.Size,
+		SHA256:   request.SHA256,
+		Status:   models.UploadStatusUploading,
+	}
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&upload).Error; err != nil {
+			return utils.NewInternalServerError("Failed to create upload")
+		}
+
+		upload.Path = filepath.Join(uploadsDir(), fmt.Sprintf("%d.part", upload.ID))
+		if err := tx.Model(&upload).Update("path", upload.Path).Error; err != nil {
+			return utils.NewInternalServerError("Failed to create upload")
+		}
+
+		file, err := os.OpenFile(upload.Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			return utils.NewInternalServerError("Failed to create upload file")
+		}
+		return file.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(201, upload)
+}
+
+// GetUpload reports the offset to resume an interrupted upload from
+func (h *UploadHandler) GetUpload(c echo.Context) error {
+	upload, err := h.findUpload(h.DB, c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(200, upload)
+}
+
+// uploadClaimTimeout is how long a part may take before another request may
+// write at the same offset, in case the link stopped while receiving it
+const uploadClaimTimeout = 30 * time.Minute
+
+// AppendUpload writes the request body at the offset given in the
+// Upload-Offset header, which must equal the bytes received so far. The
+// offset is claimed in a short transaction, so no database connection is held
+// while a slow client sends the part, and the new offset is only stored if
+// the claim still holds.
+func (h *UploadHandler) AppendUpload(c echo.Context) error {
+	offset, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return utils.NewBadRequestError("Upload-Offset header is required")
+	}
+
+	upload, err := h.claimUpload(c, offset)
+	if err != nil {
+		return err
+	}
+
+	written, hashState, err := writeUploadPart(upload, c.Request().Body)
+	if err != nil {
+		if releaseErr := h.releaseUpload(upload, upload.Offset, upload.HashState); releaseErr != nil {
+			log.Printf("Failed to release upload %d after a failed part: %v", upload.ID, releaseErr)
+		}
+		return err
+	}
+
+	if err := h.releaseUpload(upload, upload.Offset+written, hashState); err != nil {
+		return err
+	}
+
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	return c.JSON(200, upload)
+}
+
+// claimUpload reserves the current offset of an upload for one part
+func (h *UploadHandler) claimUpload(c echo.Context, offset int64) (*models.Upload, error) {
+	token, err := newClaimToken()
+	if err != nil {
+		return nil, utils.NewInternalServerError("Failed to claim upload")
+	}
+
+	var upload *models.Upload
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		upload, err = h.findUpload(tx.Clauses(clause.Locking{Strength: "UPDATE"}), c)
+		if err != nil {
+			return err
+		}
+		if upload.Status != models.UploadStatusUploading {
+			return utils.NewBadRequestError("Upload is already complete")
+		}
+		if offset != upload.Offset {
+			return uploadConflict(fmt.Sprintf("Upload-Offset %d does not match the current offset %d", offset, upload.Offset))
+		}
+		if upload.ClaimedAt != nil && time.Since(*upload.ClaimedAt) < uploadClaimTimeout {
+			return uploadConflict("Another part of this upload is being written")
+		}
+
+		now := time.Now()
+		upload.ClaimToken = token
+		upload.ClaimedAt = &now
+		return tx.Model(upload).Updates(map[string]interface{}{
+			"claim_token": upload.ClaimToken,
+			"claimed_at":  upload.ClaimedAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// claimedUpload selects an upload as long as it still has the offset and
+// claim it was read with
+func (h *UploadHandler) claimedUpload(upload *models.Upload) *gorm.DB {
+	return h.DB.Model(&models.Upload{}).
+		Where("id = ? AND `offset` = ? AND claim_token = ?", upload.ID, upload.Offset, upload.ClaimToken)
+}
+
+// releaseUpload stores the offset and hash state reached by a part and gives
+// up its claim, unless the claim was taken over in the meantime
+func (h *UploadHandler) releaseUpload(upload *models.Upload, offset int64, hashState []byte) error {
+	result := h.claimedUpload(upload).Updates(map[string]interface{}{
+		"offset":      offset,
+		"hash_state":  hashState,
+		"claim_token": "",
+		"claimed_at":  nil,
+	})
+	if result.Error != nil {
+		return utils.NewInternalServerError("Failed to update upload")
+	}
+	if result.RowsAffected == 0 {
+		return uploadConflict("The upload was changed while this part was written")
+	}
+
+	upload.Offset = offset
+	upload.HashState = hashState
+	upload.ClaimToken = ""
+	upload.ClaimedAt = nil
+	return nil
+}
+
+// writeUploadPart appends body to the upload file at its offset and returns
+// how many bytes were written together with the hash state after them. An
+// interrupted body keeps what arrived so the client can resume from there.
+func writeUploadPart(upload *models.Upload, body io.Reader) (int64, []byte, error) {
+	hasher, err := uploadHasher(upload)
+	if err != nil {
+		return 0, nil, utils.NewInternalServerError("Failed to hash upload file")
+	}
+
+	file, err := os.OpenFile(upload.Path, os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, nil, utils.NewInternalServerError("Failed to open upload file")
+	}
+	defer file.Close()
+
+	// Drop anything a previously interrupted part left behind the offset
+	if err := file.Truncate(upload.Offset); err != nil {
+		return 0, nil, utils.NewInternalServerError("Failed to prepare upload file")
+	}
+	if _, err := file.Seek(upload.Offset, io.SeekStart); err != nil {
+		return 0, nil, utils.NewInternalServerError("Failed to prepare upload file")
+	}
+
+	remaining := upload.Size - upload.Offset
+	written, err := io.Copy(io.MultiWriter(file, hasher), io.LimitReader(body, remaining+1))
+	if written > remaining {
+		file.Truncate(upload.Offset)
+		return 0, nil, utils.NewBadRequestError("Part exceeds the declared upload size")
+	}
+	if err != nil {
+		log.Printf("Upload %d interrupted after %d bytes: %v", upload.ID, written, err)
+	}
+	if err := file.Sync(); err != nil {
+		return 0, nil, utils.NewInternalServerError("Failed to write upload file")
+	}
+
+	hashState, err := hasher.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return 0, nil, utils.NewInternalServerError("Failed to hash upload file")
+	}
+	return written, hashState, nil
+}
+
+// uploadHasher returns a SHA-256 hash over the bytes of an upload before its
+// offset. Uploads without a stored hash state, such as those started before
+// it was kept, are hashed from the file.
+func uploadHasher(upload *models.Upload) (hash.Hash, error) {
+	hasher := sha256.New()
+	if len(upload.HashState) > 0 {
+		if err := hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(upload.HashState); err != nil {
+			return nil, err
+		}
+		return hasher, nil
+	}
+	if upload.Offset == 0 {
+		return hasher, nil
+	}
+
+	file, err := os.Open(upload.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, err := io.CopyN(hasher, file, upload.Offset); err != nil {
+		return nil, err
+	}
+	return hasher, nil
+}
+
+func newClaimToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+func uploadConflict(message string) error {
+	return &utils.AppError{StatusCode: 409, Message: message}
+}
+
+// CompleteUpload checks the size and SHA-256 of a fully received upload. A
+// checksum mismatch resets the upload so the client can send it again. The
+// hash was updated part by part, so the file is not read again here.
+func (h *UploadHandler) CompleteUpload(c echo.Context) error {
+	upload, err := h.findUpload(h.DB, c)
+	if err != nil {
+		return err
+	}
+	if upload.Status == models.UploadStatusCompleted {
+		return c.JSON(200, upload)
+	}
+	if upload.ClaimedAt != nil && time.Since(*upload.ClaimedAt) < uploadClaimTimeout {
+		return uploadConflict("A part of this upload is still being written")
+	}
+	if upload.Offset != upload.Size {
+		return utils.NewBadRequestError(fmt.Sprintf("Upload is incomplete: %d of %d bytes received", upload.Offset, upload.Size))
+	}
+
+	hasher, err := uploadHasher(upload)
+	if err != nil {
+		return utils.NewInternalServerError("Failed to hash upload file")
+	}
+	checksum := fmt.Sprintf("%x", hasher.Sum(nil))
+
+	// A stale claim of a part that never finished is dropped as well
+	changes := map[string]interface{}{
+		"status":      models.UploadStatusCompleted,
+		"claim_token": "",
+		"claimed_at":  nil,
+	}
+	if checksum != upload.SHA256 {
+		// The next part at offset 0 truncates the file
+		changes = map[string]interface{}{
+			"offset":      0,
+			"hash_state":  nil,
+			"claim_token": "",
+			"claimed_at":  nil,
+		}
+	}
+
+	result := h.claimedUpload(upload).Where("status = ?", models.UploadStatusUploading).Updates(changes)
+	if result.Error != nil {
+		return utils.NewInternalServerError("Failed to update upload")
+	}
+	if result.RowsAffected == 0 {
+		return uploadConflict("The upload was changed while it was checked")
+	}
+
+	if checksum != upload.SHA256 {
+		return utils.NewBadRequestError(fmt.Sprintf("Checksum mismatch: expected %s, got %s. The upload has to be sent again", upload.SHA256, checksum))
+	}
+
+	upload.Status = models.UploadStatusCompleted
+	upload.ClaimToken = ""
+	upload.ClaimedAt = nil
+	return c.JSON(200, upload)
+}
+
+func (h *UploadHandler) DeleteUpload(c echo.Context) error {
+	upload, err := h.findUpload(h.DB, c)
+	if err != nil {
+		return err
+	}
+
+	if err := removeUpload(h.DB, upload); err != nil {
+		return utils.NewInternalServerError("Failed to delete upload")
+	}
+
+	return c.NoContent(204)
+}
+
+// findUpload loads an upload of the calling user
+func (h *UploadHandler) findUpload(tx *gorm.DB, c echo.Context) (*models.Upload, error) {
+	userID, ok := c.Get("user_id").(float64)
+	if !ok {
+		return nil, utils.NewUnauthorizedError("Only users can upload experiments")
+	}
+
+	var upload models.Upload
+	if err := tx.Where("id = ? AND user_id = ?", c.Param("uploadID"), uint(userID)).First(&upload).Error; err != nil {
+		return nil, utils.NewNotFoundError("Upload not found")
+	}
+	return &upload, nil
+}
+
+func removeUpload(db *gorm.DB, upload *models.Upload) error {
+	if err := os.Remove(upload.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return db.Delete(upload).Error
+}
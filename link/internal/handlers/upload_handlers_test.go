@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"link/internal/config"
+	"link/internal/models"
+	"link/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+type uploadTest struct {
+	t       *testing.T
+	handler *UploadHandler
+	echo    *echo.Echo
+}
+
+func newUploadTest(t *testing.T) *uploadTest {
+	t.Helper()
+
+	// Uploads are stored relative to the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	return &uploadTest{
+		t:       t,
+		handler: &UploadHandler{DB: newTestDB(t), Config: &config.Config{}},
+		echo:    echo.New(),
+	}
+}
+
+func (u *uploadTest) context(method, body string, uploadID uint) (echo.Context, *httptest.ResponseRecorder) {
+	request := httptest.NewRequest(method, "/", strings.NewReader(body))
+	request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	recorder := httptest.NewRecorder()
+	c := u.echo.NewContext(request, recorder)
+	c.Set("user_id", float64(1))
+	if uploadID != 0 {
+		c.SetParamNames("uploadID")
+		c.SetParamValues(strconv.FormatUint(uint64(uploadID), 10))
+	}
+	return c, recorder
+}
+
+func (u *uploadTest) create(content string) uint {
+	u.t.Helper()
+	body := fmt.Sprintf(`{"file_name": "app.zip", "size": %d, "sha256": "%x"}`, len(content), sha256.Sum256([]byte(content)))
+	c, _ := u.context(http.MethodPost, body, 0)
+	if err := u.handler.CreateUpload(c); err != nil {
+		u.t.Fatalf("CreateUpload() error = %v", err)
+	}
+
+	var upload models.Upload
+	if err := u.handler.DB.Order("id DESC").First(&upload).Error; err != nil {
+		u.t.Fatal(err)
+	}
+	return upload.ID
+}
+
+func (u *uploadTest) appendPart(uploadID uint, offset int64, part string) error {
+	c, _ := u.context(http.MethodPatch, part, uploadID)
+	c.Request().Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	return u.handler.AppendUpload(c)
+}
+
+func (u *uploadTest) complete(uploadID uint) error {
+	c, _ := u.context(http.MethodPost, "", uploadID)
+	return u.handler.CompleteUpload(c)
+}
+
+func (u *uploadTest) load(uploadID uint) models.Upload {
+	u.t.Helper()
+	var upload models.Upload
+	if err := u.handler.DB.First(&upload, uploadID).Error; err != nil {
+		u.t.Fatal(err)
+	}
+	return upload
+}
+
+func wantStatus(t *testing.T, what string, err error, status int) {
+	t.Helper()
+	if status == 200 {
+		if err != nil {
+			t.Fatalf("%s error = %v", what, err)
+		}
+		return
+	}
+	var appErr *utils.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode != status {
+		t.Fatalf("%s error = %v, want %d", what, err, status)
+	}
+}
+
+func TestAppendUploadOffsets(t *testing.T) {
+	u := newUploadTest(t)
+	id := u.create("hello world")
+
+	wantStatus(t, "first part", u.appendPart(id, 0, "hello "), 200)
+	if upload := u.load(id); upload.Offset != 6 || upload.ClaimToken != "" || upload.ClaimedAt != nil {
+		t.Fatalf("upload after the first part = %+v", upload)
+	}
+
+	// Parts at any other offset than the current one conflict
+	wantStatus(t, "repeated part", u.appendPart(id, 0, "hello "), 409)
+	wantStatus(t, "part behind the offset", u.appendPart(id, 3, "lo world"), 409)
+	wantStatus(t, "part past the offset", u.appendPart(id, 8, "rld"), 409)
+	wantStatus(t, "incomplete upload", u.complete(id), 400)
+	wantStatus(t, "part larger than the upload", u.appendPart(id, 6, "world and more"), 400)
+	if upload := u.load(id); upload.Offset != 6 {
+		t.Fatalf("offset after rejected parts = %d, want 6", upload.Offset)
+	}
+
+	wantStatus(t, "last part", u.appendPart(id, 6, "world"), 200)
+	wantStatus(t, "complete", u.complete(id), 200)
+	if upload := u.load(id); upload.Status != models.UploadStatusCompleted || upload.Offset != 11 {
+		t.Fatalf("completed upload = %+v", upload)
+	}
+	data, err := os.ReadFile(u.load(id).Path)
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("upload file = %q, %v", data, err)
+	}
+
+	wantStatus(t, "repeated complete", u.complete(id), 200)
+	wantStatus(t, "part after completion", u.appendPart(id, 11, "!"), 400)
+}
+
+func TestAppendUploadClaim(t *testing.T) {
+	u := newUploadTest(t)
+	id := u.create("hello world")
+
+	// A part still being written blocks other parts and completion
+	claimedAt := time.Now()
+	if err := u.handler.DB.Model(&models.Upload{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"claim_token": "writer", "claimed_at": claimedAt}).Error; err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, "part during another part", u.appendPart(id, 0, "hello world"), 409)
+	wantStatus(t, "complete during a part", u.complete(id), 409)
+
+	// The claim of a writer that went away expires
+	if err := u.handler.DB.Model(&models.Upload{}).Where("id = ?", id).
+		Update("claimed_at", claimedAt.Add(-2*uploadClaimTimeout)).Error; err != nil {
+		t.Fatal(err)
+	}
+	wantStatus(t, "part after an expired claim", u.appendPart(id, 0, "hello world"), 200)
+
+	// Its release no longer holds once the claim was taken over
+	upload := u.load(id)
+	upload.ClaimToken = "writer"
+	wantStatus(t, "release of a lost claim", u.handler.releaseUpload(&upload, 11, nil), 409)
+
+	wantStatus(t, "complete", u.complete(id), 200)
+}
+
+func TestCompleteUploadChecksumMismatch(t *testing.T) {
+	u := newUploadTest(t)
+	id := u.create("hello world")
+
+	wantStatus(t, "corrupted part", u.appendPart(id, 0, "hello wor1d"), 200)
+	wantStatus(t, "complete", u.complete(id), 400)
+
+	// The upload starts over
+	upload := u.load(id)
+	if upload.Status != models.UploadStatusUploading || upload.Offset != 0 || upload.HashState != nil {
+		t.Fatalf("upload after a checksum mismatch = %+v", upload)
+	}
+
+	wantStatus(t, "resent part", u.appendPart(id, 0, "hello world"), 200)
+	wantStatus(t, "complete", u.complete(id), 200)
+}
+
+func TestCompleteUploadWithoutHashState(t *testing.T) {
+	u := newUploadTest(t)
+	id := u.create("hello world")
+
+	// Uploads started before the hash state was kept are hashed from the file
+	wantStatus(t, "first part", u.appendPart(id, 0, "hello "), 200)
+	if err := u.handler.DB.Model(&models.Upload{}).Where("id = ?", id).Update("hash_state", nil).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	wantStatus(t, "last part", u.appendPart(id, 6, "world"), 200)
+	wantStatus(t, "complete", u.complete(id), 200)
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+
+	"link/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type WheelhouseHandler struct {
+	DB        *gorm.DB
+	PythonEnv *utils.PythonEnv
+}
+
+func (h *WheelhouseHandler) ListWheels(c echo.Context) error {
+	wheels, err := h.PythonEnv.ListWheels()
+	if err != nil {
+		return utils.NewInternalServerError("Failed to read the wheelhouse")
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"offline": h.PythonEnv.Offline(),
+		"wheels":  wheels,
+	})
+}
+
+// UploadWheels stores every file sent as wheels in the wheelhouse. All file
+// names are checked before any wheel is stored.
+func (h *WheelhouseHandler) UploadWheels(c echo.Context) error {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return utils.NewBadRequestError("Failed to parse form data")
+	}
+
+	files := form.File["wheels"]
+	if len(files) == 0 {
+		return utils.NewBadRequestError("No wheels were uploaded")
+	}
+
+	var problems []utils.FieldError
+	for _, file := range files {
+		if !utils.IsWheelFileName(file.Filename) {
+			problems = append(problems, utils.FieldError{Field: file.Filename, Message: "is not a wheel file name"})
+		}
+	}
+	if len(problems) > 0 {
+		return utils.NewValidationError("Invalid wheels", problems)
+	}
+
+	stored := make([]string, 0, len(files))
+	for _, file := range files {
+		src, err := file.Open()
+		if err != nil {
+			return utils.NewInternalServerError(fmt.Sprintf("Failed to open %s", file.Filename))
+		}
+		err = h.PythonEnv.SaveWheel(file.Filename, src)
+		src.Close()
+		if err != nil {
+			log.Printf("Failed to store wheel %s: %v", file.Filename, err)
+			return utils.NewInternalServerError(fmt.Sprintf("Failed to store %s", file.Filename))
+		}
+		stored = append(stored, file.Filename)
+	}
+
+	log.Printf("%s added %d wheels to the wheelhouse", userActor(c), len(stored))
+	return c.JSON(201, map[string]interface{}{"stored": stored})
+}
+
+func (h *WheelhouseHandler) DeleteWheel(c echo.Context) error {
+	name := c.Param("name")
+	if err := h.PythonEnv.RemoveWheel(name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return utils.NewNotFoundError("Wheel not found")
+		}
+		return utils.NewInternalServerError("Failed to remove wheel")
+	}
+
+	return c.NoContent(204)
+}
+
+// CheckExperimentRequirements reports which requirements of the experiment's
+// current revision cannot be installed from the wheelhouse alone
+func (h *WheelhouseHandler) CheckExperimentRequirements(c echo.Context) error {
+	experimentID, err := parseExperimentID(c.Param("id"))
+	if err != nil {
+		return err
+	}
+	experiment, err := authorizeExperimentAccess(c, h.DB, experimentID)
+	if err != nil {
+		return err
+	}
+
+	requirements, err := h.PythonEnv.CheckWheelhouse(experiment.BasePath)
+	if err != nil {
+		return utils.NewInternalServerError(fmt.Sprintf("Failed to check the experiment requirements: %v", err))
+	}
+
+	missing := []string{}
+	for _, requirement := range requirements {
+		if !requirement.Satisfied {
+			missing = append(missing, requirement.Requirement)
+		}
+	}
+
+	return c.JSON(200, map[string]interface{}{
+		"experiment_id": experiment.ID,
+		"revision":      experiment.Revision,
+		"offline":       h.PythonEnv.Offline(),
+		"satisfied":     len(missing) == 0,
+		"missing":       missing,
+		"requirements":  requirements,
+	})
+}
@@ -0,0 +1,343 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"link/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HandlerFunc executes one attempt of a job. Progress written to the logger
+// is stored with the job.
+type HandlerFunc func(ctx context.Context, job *models.Job, logger *Logger) error
+
+// InterruptedFunc cleans up after a job that was running when the link
+// stopped. It is called on startup once the job is marked as failed.
+type InterruptedFunc func(job *models.Job)
+
+// Options controls how a job is enqueued
+type Options struct {
+	ExperimentID *uint
+	MaxAttempts  int
+	// Unique returns the queued or running job of the same type and
+	// experiment instead of enqueueing another one
+	Unique bool
+}
+
+// Runner is the database-backed queue of background jobs. Workers claim
+// queued jobs in order of arrival, so jobs survive restarts of the link until
+// they are started; jobs interrupted while running are failed on startup.
+type Runner struct {
+	db           *gorm.DB
+	pollInterval time.Duration
+	retryDelay   time.Duration
+	handlers     map[string]HandlerFunc
+	interrupted  map[string]InterruptedFunc
+	wake         chan struct{}
+	mu           sync.RWMutex
+}
+
+func NewRunner(db *gorm.DB, pollInterval, retryDelay time.Duration) *Runner {
+	return &Runner{
+		db:           db,
+		pollInterval: pollInterval,
+		retryDelay:   retryDelay,
+		handlers:     make(map[string]HandlerFunc),
+		interrupted:  make(map[string]InterruptedFunc),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Register sets the handler executing jobs of jobType
+func (r *Runner) Register(jobType string, handler HandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[jobType] = handler
+}
+
+// OnInterrupted sets the function cleaning up jobs of jobType that were
+// interrupted by a restart
+func (r *Runner) OnInterrupted(jobType string, handler InterruptedFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interrupted[jobType] = handler
+}
+
+// Enqueue records a job with its JSON encoded payload in tx. Workers pick it
+// up once tx is committed.
+func (r *Runner) Enqueue(tx *gorm.DB, jobType string, payload interface{}, options Options) (*models.Job, error) {
+	if options.Unique {
+		var existing models.Job
+		query := tx.Where("type = ? AND status IN ?", jobType, []models.JobStatus{models.JobStatusQueued, models.JobStatusRunning})
+		if options.ExperimentID != nil {
+			query = query.Where("experiment_id = ?", *options.ExperimentID)
+		}
+		err := query.Order("id").First(&existing).Error
+		if err == nil {
+			return &existing, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job payload: %w", err)
+	}
+
+	if options.MaxAttempts < 1 {
+		options.MaxAttempts = 1
+	}
+
+	job := models.Job{
+		Type:         jobType,
+		Status:       models.JobStatusQueued,
+		ExperimentID: options.ExperimentID,
+		Payload:      string(data),
+		MaxAttempts:  options.MaxAttempts,
+		RunAt:        time.Now(),
+	}
+	if err := tx.Create(&job).Error; err != nil {
+		return nil, err
+	}
+
+	r.Wake()
+	return &job, nil
+}
+
+// Retry queues a failed job again with one more attempt
+func (r *Runner) Retry(id uint) (*models.Job, error) {
+	var job models.Job
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&job, id).Error; err != nil {
+			return err
+		}
+		if job.Status != models.JobStatusFailed {
+			return fmt.Errorf("only failed jobs can be retried, job %d is %s", job.ID, job.Status)
+		}
+
+		job.Status = models.JobStatusQueued
+		job.MaxAttempts = job.Attempts + 1
+		job.RunAt = time.Now()
+		job.FinishedAt = nil
+		return tx.Save(&job).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.Wake()
+	return &job, nil
+}
+
+// Wake lets an idle worker look for queued jobs without waiting for the next poll
+func (r *Runner) Wake() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run fails the jobs interrupted by the last shutdown and then executes
+// queued jobs with the given number of workers. It returns when the context
+// is cancelled.
+func (r *Runner) Run(ctx context.Context, workers int) {
+	r.failInterrupted()
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// failInterrupted marks the jobs that were running when the link stopped as
+// failed and lets their OnInterrupted functions clean up after them
+func (r *Runner) failInterrupted() {
+	var interrupted []models.Job
+	if err := r.db.Where("status = ?", models.JobStatusRunning).Order("id").Find(&interrupted).Error; err != nil {
+		log.Printf("Failed to find interrupted jobs: %v", err)
+		return
+	}
+
+	for i := range interrupted {
+		job := &interrupted[i]
+		result := r.db.Model(job).
+			Where("status = ?", models.JobStatusRunning).
+			Updates(map[string]interface{}{
+				"status":      models.JobStatusFailed,
+				"error":       "interrupted by a restart of the link",
+				"finished_at": time.Now(),
+			})
+		if result.Error != nil {
+			log.Printf("Failed to fail interrupted job %d: %v", job.ID, result.Error)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		log.Printf("Marked interrupted job %d (%s) as failed", job.ID, job.Type)
+
+		r.mu.RLock()
+		handler, ok := r.interrupted[job.Type]
+		r.mu.RUnlock()
+		if ok {
+			handler(job)
+		}
+	}
+}
+
+func (r *Runner) work(ctx context.Context) {
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			job, err := r.claim()
+			if err != nil {
+				log.Printf("Failed to claim job: %v", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			r.execute(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// claim marks the oldest due job as running. Rows locked by another worker
+// are skipped.
+func (r *Runner) claim() (*models.Job, error) {
+	var job models.Job
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND run_at <= ?", models.JobStatusQueued, time.Now()).
+			Order("id").
+			First(&job).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		job.Status = models.JobStatusRunning
+		job.Attempts++
+		job.StartedAt = &now
+		return tx.Model(&job).Updates(map[string]interface{}{
+			"status":     job.Status,
+			"attempts":   job.Attempts,
+			"started_at": now,
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *Runner) execute(ctx context.Context, job *models.Job) {
+	logger := &Logger{db: r.db, job: job}
+
+	r.mu.RLock()
+	handler, ok := r.handlers[job.Type]
+	r.mu.RUnlock()
+
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler for job type %s", job.Type)
+	} else {
+		logger.Printf("Attempt %d of %d started", job.Attempts, job.MaxAttempts)
+		err = runHandler(ctx, handler, job, logger)
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":      models.JobStatusSucceeded,
+		"error":       "",
+		"finished_at": now,
+	}
+	switch {
+	case err == nil:
+		logger.Printf("Succeeded")
+	case job.Attempts < job.MaxAttempts:
+		delay := r.retryDelay * time.Duration(job.Attempts)
+		logger.Printf("Failed, retrying in %s: %v", delay, err)
+		updates = map[string]interface{}{
+			"status":      models.JobStatusQueued,
+			"error":       err.Error(),
+			"run_at":      now.Add(delay),
+			"finished_at": nil,
+		}
+	default:
+		logger.Printf("Failed: %v", err)
+		updates["status"] = models.JobStatusFailed
+		updates["error"] = err.Error()
+	}
+
+	if err := r.db.Model(job).Updates(updates).Error; err != nil {
+		log.Printf("Failed to update job %d: %v", job.ID, err)
+	}
+}
+
+// runHandler turns a panicking job into a failed attempt
+func runHandler(ctx context.Context, handler HandlerFunc, job *models.Job, logger *Logger) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+	return handler(ctx, job, logger)
+}
+
+// DecodePayload decodes the JSON payload of a job into v
+func DecodePayload(job *models.Job, v interface{}) error {
+	if err := json.Unmarshal([]byte(job.Payload), v); err != nil {
+		return fmt.Errorf("invalid payload for job %d: %w", job.ID, err)
+	}
+	return nil
+}
+
+// Logger stores the progress of a job attempt and mirrors it to the server log
+type Logger struct {
+	db  *gorm.DB
+	job *models.Job
+}
+
+func (l *Logger) Printf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Printf("Job %d (%s): %s", l.job.ID, l.job.Type, message)
+
+	if err := l.db.Create(&models.JobLog{
+		JobID:   l.job.ID,
+		Attempt: l.job.Attempts,
+		Message: message,
+	}).Error; err != nil {
+		log.Printf("Failed to store log of job %d: %v", l.job.ID, err)
+	}
+}
@@ -0,0 +1,233 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"link/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestRunner(t *testing.T, retryDelay time.Duration) *Runner {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "link.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Job{}, &models.JobLog{}); err != nil {
+		t.Fatal(err)
+	}
+	return NewRunner(db, 10*time.Millisecond, retryDelay)
+}
+
+func enqueue(t *testing.T, r *Runner, jobType string, options Options) *models.Job {
+	t.Helper()
+	job, err := r.Enqueue(r.db, jobType, map[string]int{"value": 1}, options)
+	if err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	return job
+}
+
+func claim(t *testing.T, r *Runner) *models.Job {
+	t.Helper()
+	job, err := r.claim()
+	if err != nil {
+		t.Fatalf("claim() error = %v", err)
+	}
+	return job
+}
+
+func loadJob(t *testing.T, r *Runner, id uint) models.Job {
+	t.Helper()
+	var job models.Job
+	if err := r.db.First(&job, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestClaim(t *testing.T) {
+	r := newTestRunner(t, time.Hour)
+	first := enqueue(t, r, "a", Options{})
+	second := enqueue(t, r, "b", Options{})
+
+	// Jobs are claimed in order of arrival, each only once
+	for _, want := range []*models.Job{first, second} {
+		job := claim(t, r)
+		if job == nil || job.ID != want.ID {
+			t.Fatalf("claim() = %+v, want job %d", job, want.ID)
+		}
+		if job.Status != models.JobStatusRunning || job.Attempts != 1 || job.StartedAt == nil {
+			t.Fatalf("claimed job = %+v", job)
+		}
+		if stored := loadJob(t, r, job.ID); stored.Status != models.JobStatusRunning || stored.Attempts != 1 {
+			t.Fatalf("stored claimed job = %+v", stored)
+		}
+	}
+	if job := claim(t, r); job != nil {
+		t.Fatalf("claim() = %+v, want no job", job)
+	}
+
+	// Jobs waiting for a retry are not due yet
+	delayed := enqueue(t, r, "c", Options{})
+	if err := r.db.Model(delayed).Update("run_at", time.Now().Add(time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if job := claim(t, r); job != nil {
+		t.Fatalf("claim() = %+v, want no due job", job)
+	}
+}
+
+func TestEnqueueUnique(t *testing.T) {
+	r := newTestRunner(t, time.Hour)
+	experimentID, otherID := uint(1), uint(2)
+
+	job := enqueue(t, r, "start", Options{ExperimentID: &experimentID, Unique: true})
+	if again := enqueue(t, r, "start", Options{ExperimentID: &experimentID, Unique: true}); again.ID != job.ID {
+		t.Fatalf("Enqueue() of a queued unique job = %d, want %d", again.ID, job.ID)
+	}
+	if other := enqueue(t, r, "start", Options{ExperimentID: &otherID, Unique: true}); other.ID == job.ID {
+		t.Fatal("Enqueue() returned the job of another experiment")
+	}
+
+	// A finished job no longer counts
+	if err := r.db.Model(job).Update("status", models.JobStatusSucceeded).Error; err != nil {
+		t.Fatal(err)
+	}
+	if again := enqueue(t, r, "start", Options{ExperimentID: &experimentID, Unique: true}); again.ID == job.ID {
+		t.Fatal("Enqueue() returned a finished job")
+	}
+}
+
+func TestExecuteRetries(t *testing.T) {
+	r := newTestRunner(t, time.Hour)
+	attempts := 0
+	r.Register("flaky", func(ctx context.Context, job *models.Job, logger *Logger) error {
+		attempts++
+		logger.Printf("attempt %d", attempts)
+		return errors.New("broken")
+	})
+	job := enqueue(t, r, "flaky", Options{MaxAttempts: 2})
+
+	r.execute(context.Background(), claim(t, r))
+	queued := loadJob(t, r, job.ID)
+	if queued.Status != models.JobStatusQueued || queued.Error != "broken" || !queued.RunAt.After(time.Now().Add(30*time.Minute)) {
+		t.Fatalf("job after the first failed attempt = %+v", queued)
+	}
+
+	// The retry is due once its delay has passed
+	if err := r.db.Model(job).Update("run_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	r.execute(context.Background(), claim(t, r))
+	failed := loadJob(t, r, job.ID)
+	if failed.Status != models.JobStatusFailed || failed.Attempts != 2 || failed.FinishedAt == nil {
+		t.Fatalf("job after the last failed attempt = %+v", failed)
+	}
+	if job := claim(t, r); job != nil {
+		t.Fatalf("claim() = %+v, want no job", job)
+	}
+
+	var logs []models.JobLog
+	if err := r.db.Where("job_id = ?", job.ID).Find(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 || len(logs) == 0 || logs[len(logs)-1].Attempt != 2 {
+		t.Fatalf("attempts = %d, logs = %+v", attempts, logs)
+	}
+
+	retried, err := r.Retry(job.ID)
+	if err != nil {
+		t.Fatalf("Retry() error = %v", err)
+	}
+	if retried.Status != models.JobStatusQueued || retried.MaxAttempts != 3 || retried.FinishedAt != nil {
+		t.Fatalf("retried job = %+v", retried)
+	}
+	if _, err := r.Retry(job.ID); err == nil {
+		t.Fatal("Retry() of a queued job succeeded")
+	}
+}
+
+func TestExecuteFailures(t *testing.T) {
+	r := newTestRunner(t, time.Hour)
+	r.Register("panics", func(ctx context.Context, job *models.Job, logger *Logger) error {
+		panic("boom")
+	})
+	r.Register("works", func(ctx context.Context, job *models.Job, logger *Logger) error {
+		var payload map[string]int
+		if err := DecodePayload(job, &payload); err != nil {
+			return err
+		}
+		if payload["value"] != 1 {
+			return errors.New("unexpected payload")
+		}
+		return nil
+	})
+
+	tests := []struct {
+		jobType string
+		status  models.JobStatus
+		wantErr string
+	}{
+		{jobType: "works", status: models.JobStatusSucceeded},
+		{jobType: "panics", status: models.JobStatusFailed, wantErr: "job panicked: boom"},
+		{jobType: "unknown", status: models.JobStatusFailed, wantErr: "no handler for job type unknown"},
+	}
+
+	for _, tt := range tests {
+		job := enqueue(t, r, tt.jobType, Options{})
+		r.execute(context.Background(), claim(t, r))
+		if got := loadJob(t, r, job.ID); got.Status != tt.status || got.Error != tt.wantErr {
+			t.Errorf("%s job = %s %q, want %s %q", tt.jobType, got.Status, got.Error, tt.status, tt.wantErr)
+		}
+	}
+}
+
+func TestRunFailsInterruptedJobs(t *testing.T) {
+	r := newTestRunner(t, time.Hour)
+	interrupted := enqueue(t, r, "start", Options{})
+	claim(t, r)
+	queued := enqueue(t, r, "start", Options{})
+
+	var cleanedUp []uint
+	r.OnInterrupted("start", func(job *models.Job) {
+		cleanedUp = append(cleanedUp, job.ID)
+	})
+	done := make(chan uint, 1)
+	r.Register("start", func(ctx context.Context, job *models.Job, logger *Logger) error {
+		done <- job.ID
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		r.Run(ctx, 2)
+		close(stopped)
+	}()
+
+	select {
+	case id := <-done:
+		if id != queued.ID {
+			t.Fatalf("Run() executed job %d, want the queued job %d", id, queued.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not execute the queued job")
+	}
+	cancel()
+	<-stopped
+
+	if job := loadJob(t, r, interrupted.ID); job.Status != models.JobStatusFailed || job.Error != "interrupted by a restart of the link" {
+		t.Fatalf("interrupted job = %+v", job)
+	}
+	if len(cleanedUp) != 1 || cleanedUp[0] != interrupted.ID {
+		t.Fatalf("OnInterrupted() called for %v, want [%d]", cleanedUp, interrupted.ID)
+	}
+}
@@ -63,3 +63,26 @@ func CombinedAuthMiddleware(db *gorm.DB, secretKey string) echo.MiddlewareFunc {
 		}
 	}
 }
+
+// RequireAdmin only lets authenticated users with the admin role through. It
+// must run after CombinedAuthMiddleware.
+func RequireAdmin(db *gorm.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, ok := c.Get("user_id").(float64)
+			if !ok {
+				return utils.NewForbiddenError("Admin access required")
+			}
+
+			var user models.User
+			if err := db.Select("id", "approved", "admin").First(&user, uint(userID)).Error; err != nil {
+				return utils.NewUnauthorizedError("User not found")
+			}
+			if !user.Approved || !user.Admin {
+				return utils.NewForbiddenError("Admin access required")
+			}
+
+			return next(c)
+		}
+	}
+}
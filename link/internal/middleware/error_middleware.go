@@ -18,12 +18,16 @@ func ErrorHandler(next echo.HandlerFunc) echo.HandlerFunc {
 
 			switch e := err.(type) {
 			case *utils.AppError:
-				return c.JSON(e.StatusCode, map[string]interface{}{
+				response := map[string]interface{}{
 					"error":       e.Message,
 					"status_code": e.StatusCode,
 					"file":        file,
 					"line":        line,
-				})
+				}
+				if len(e.Fields) > 0 {
+					response["fields"] = e.Fields
+				}
+				return c.JSON(e.StatusCode, response)
 			case *echo.HTTPError:
 				return c.JSON(e.Code, map[string]interface{}{
 					"error":       e.Message,
@@ -10,9 +10,11 @@ type Experiment struct {
 	Name        string
 	Description string
 	BasePath    string
-	Status      string
+	Revision    int
+	Status      ExperimentStatus `gorm:"type:varchar(32)"`
 	CreatedAt   time.Time `gorm:"autoCreateTime"`
 	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
 	User        User `gorm:"foreignKey:UserID"`
 	ExperimentNodes []ExperimentNode `gorm:"foreignKey:ExperimentID"`
+	Runs            []Run            `gorm:"foreignKey:ExperimentID"`
 }
@@ -19,6 +19,8 @@ type ExperimentNode struct {
 	NodeID       uint `gorm:"primaryKey"`
 	MetadataID   uint `gorm:"primaryKey"`
 	Status       ExperimentNodeStatus
+	MismatchedFiles []string `gorm:"serializer:json;type:text"`
+	RemovedFiles    []string `gorm:"serializer:json;type:text"`
 	Experiment   Experiment `gorm:"foreignKey:ExperimentID"`
 	Node         Node       `gorm:"foreignKey:NodeID"`
 	Metadata     Metadata   `gorm:"foreignKey:MetadataID"`
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+type QueueEntryStatus string
+
+const (
+	QueueEntryStatusQueued    QueueEntryStatus = "QUEUED"
+	QueueEntryStatusStarted   QueueEntryStatus = "STARTED"
+	QueueEntryStatusCancelled QueueEntryStatus = "CANCELLED"
+	QueueEntryStatusFailed    QueueEntryStatus = "FAILED"
+)
+
+// ExperimentQueueEntry is a start request waiting for a free federation slot.
+// Entries are started by descending priority and then in order of arrival.
+type ExperimentQueueEntry struct {
+	ID           uint `gorm:"primaryKey"`
+	ExperimentID uint `gorm:"index"`
+	UserID       uint
+	Priority     int
+	RunConfig    map[string]interface{} `gorm:"serializer:json;type:text"`
+	Status       QueueEntryStatus       `gorm:"type:varchar(32);index"`
+	Error        string
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	StartedAt    *time.Time
+	Experiment   Experiment `gorm:"foreignKey:ExperimentID"`
+}
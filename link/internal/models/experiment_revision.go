@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// ExperimentRevision is an immutable snapshot of an experiment's files. Every
+// upload and update creates a new revision in its own directory.
+type ExperimentRevision struct {
+	ID           uint `gorm:"primaryKey"`
+	ExperimentID uint `gorm:"uniqueIndex:idx_experiment_revision"`
+	Number       int  `gorm:"uniqueIndex:idx_experiment_revision"`
+	BasePath     string
+	ManifestHash string          `gorm:"type:varchar(64)"`
+	Manifest     []ManifestEntry `gorm:"serializer:json;type:longtext"`
+	Signature    string          `gorm:"type:text"`
+	Actor        string
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+}
+
+// ManifestEntry is a file of an experiment package, relative to its app folder
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
@@ -0,0 +1,68 @@
+package models
+
+import "time"
+
+type ExperimentStatus string
+
+const (
+	ExperimentStatusDraft         ExperimentStatus = "DRAFT"
+	ExperimentStatusAwaitingNodes ExperimentStatus = "AWAITING_NODES"
+	ExperimentStatusReady         ExperimentStatus = "READY"
+	ExperimentStatusPreparing     ExperimentStatus = "PREPARING"
+	ExperimentStatusTraining      ExperimentStatus = "TRAINING"
+	ExperimentStatusCompleted     ExperimentStatus = "COMPLETED"
+	ExperimentStatusFailed        ExperimentStatus = "FAILED"
+	ExperimentStatusStopped       ExperimentStatus = "STOPPED"
+)
+
+// experimentTransitions lists the statuses each experiment status may move to.
+// Finished experiments can be trained again or sent back to the nodes after an
+// update, and a start whose SuperLink never became ready returns to READY.
+var experimentTransitions = map[ExperimentStatus][]ExperimentStatus{
+	ExperimentStatusDraft:         {ExperimentStatusAwaitingNodes},
+	ExperimentStatusAwaitingNodes: {ExperimentStatusReady},
+	ExperimentStatusReady:         {ExperimentStatusAwaitingNodes, ExperimentStatusPreparing},
+	ExperimentStatusPreparing:     {ExperimentStatusReady, ExperimentStatusTraining, ExperimentStatusFailed, ExperimentStatusStopped},
+	ExperimentStatusTraining:      {ExperimentStatusCompleted, ExperimentStatusFailed, ExperimentStatusStopped},
+	ExperimentStatusCompleted:     {ExperimentStatusAwaitingNodes, ExperimentStatusReady, ExperimentStatusPreparing},
+	ExperimentStatusFailed:        {ExperimentStatusAwaitingNodes, ExperimentStatusReady, ExperimentStatusPreparing},
+	ExperimentStatusStopped:       {ExperimentStatusAwaitingNodes, ExperimentStatusReady, ExperimentStatusPreparing},
+}
+
+// ActiveExperimentStatuses are the statuses of an experiment holding a federation
+var ActiveExperimentStatuses = []ExperimentStatus{
+	ExperimentStatusPreparing,
+	ExperimentStatusTraining,
+}
+
+// CanTransitionTo reports whether an experiment may move from s to next
+func (s ExperimentStatus) CanTransitionTo(next ExperimentStatus) bool {
+	for _, allowed := range experimentTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsKnown reports whether s is one of the typed experiment statuses. Rows
+// written before statuses were typed may hold anything else.
+func (s ExperimentStatus) IsKnown() bool {
+	_, ok := experimentTransitions[s]
+	return ok
+}
+
+func (s ExperimentStatus) IsActive() bool {
+	return s == ExperimentStatusPreparing || s == ExperimentStatusTraining
+}
+
+// ExperimentStatusTransition is an append-only record of an experiment status change
+type ExperimentStatusTransition struct {
+	ID           uint             `gorm:"primaryKey"`
+	ExperimentID uint             `gorm:"index"`
+	FromStatus   ExperimentStatus `gorm:"type:varchar(32)"`
+	ToStatus     ExperimentStatus `gorm:"type:varchar(32)"`
+	Actor        string
+	Reason       string
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+}
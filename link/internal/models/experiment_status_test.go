@@ -0,0 +1,61 @@
+package models
+
+import "testing"
+
+func TestCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from, to ExperimentStatus
+		want     bool
+	}{
+		{ExperimentStatusDraft, ExperimentStatusAwaitingNodes, true},
+		{ExperimentStatusDraft, ExperimentStatusReady, false},
+		{ExperimentStatusAwaitingNodes, ExperimentStatusReady, true},
+		{ExperimentStatusAwaitingNodes, ExperimentStatusPreparing, false},
+		{ExperimentStatusReady, ExperimentStatusPreparing, true},
+		{ExperimentStatusReady, ExperimentStatusAwaitingNodes, true},
+		{ExperimentStatusReady, ExperimentStatusTraining, false},
+		{ExperimentStatusPreparing, ExperimentStatusReady, true},
+		{ExperimentStatusPreparing, ExperimentStatusTraining, true},
+		{ExperimentStatusPreparing, ExperimentStatusCompleted, false},
+		{ExperimentStatusTraining, ExperimentStatusCompleted, true},
+		{ExperimentStatusTraining, ExperimentStatusFailed, true},
+		{ExperimentStatusTraining, ExperimentStatusStopped, true},
+		{ExperimentStatusTraining, ExperimentStatusReady, false},
+		{ExperimentStatusCompleted, ExperimentStatusPreparing, true},
+		{ExperimentStatusFailed, ExperimentStatusAwaitingNodes, true},
+		{ExperimentStatusStopped, ExperimentStatusReady, true},
+		{ExperimentStatusCompleted, ExperimentStatusTraining, false},
+		{ExperimentStatusCompleted, ExperimentStatusDraft, false},
+		{"PENDING", ExperimentStatusReady, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+			t.Errorf("%s.CanTransitionTo(%s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestExperimentStatusKinds(t *testing.T) {
+	for status := range experimentTransitions {
+		if !status.IsKnown() {
+			t.Errorf("%s.IsKnown() = false", status)
+		}
+		active := status == ExperimentStatusPreparing || status == ExperimentStatusTraining
+		if status.IsActive() != active {
+			t.Errorf("%s.IsActive() = %v, want %v", status, status.IsActive(), active)
+		}
+		// Every status a transition leads to has transitions of its own
+		for _, next := range experimentTransitions[status] {
+			if !next.IsKnown() {
+				t.Errorf("%s leads to unknown status %s", status, next)
+			}
+		}
+	}
+
+	for _, status := range []ExperimentStatus{"PENDING", "ACCEPTED", ""} {
+		if status.IsKnown() {
+			t.Errorf("%q.IsKnown() = true", status)
+		}
+	}
+}
@@ -11,8 +11,22 @@ const (
 	InstructionUpdateExperiment InstructionType = "UPDATE_EXPERIMENT"
 )
 
+type InstructionStatus string
+
+const (
+	InstructionStatusPending      InstructionStatus = "PENDING"
+	InstructionStatusDelivered    InstructionStatus = "DELIVERED"
+	InstructionStatusAcknowledged InstructionStatus = "ACKNOWLEDGED"
+)
+
 type Instruction struct {
-	Type      InstructionType
-	Payload   interface{}
-	CreatedAt time.Time `gorm:"autoCreateTime"`
+	ID          uint `gorm:"primaryKey"`
+	NodeID      uint `gorm:"index:idx_node_instruction_status"`
+	Type        InstructionType
+	Payload     interface{}       `gorm:"serializer:json;type:text"`
+	Status      InstructionStatus `gorm:"type:varchar(32);index:idx_node_instruction_status"`
+	Attempts    int
+	DeliveredAt *time.Time
+	AckedAt     *time.Time
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
 }
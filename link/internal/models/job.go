@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "QUEUED"
+	JobStatusRunning   JobStatus = "RUNNING"
+	JobStatusSucceeded JobStatus = "SUCCEEDED"
+	JobStatusFailed    JobStatus = "FAILED"
+)
+
+// Job is a long-running server task executed in the background. Failed
+// attempts are retried until MaxAttempts is reached.
+type Job struct {
+	ID           uint      `gorm:"primaryKey"`
+	Type         string    `gorm:"type:varchar(64);index"`
+	Status       JobStatus `gorm:"type:varchar(32);index"`
+	ExperimentID *uint     `gorm:"index"`
+	Payload      string    `gorm:"type:text"`
+	Attempts     int
+	MaxAttempts  int
+	Error        string    `gorm:"type:text"`
+	RunAt        time.Time `gorm:"index"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
+	StartedAt    *time.Time
+	FinishedAt   *time.Time
+	Logs         []JobLog `gorm:"foreignKey:JobID"`
+}
+
+// JobLog is a line of progress written by a job while it runs
+type JobLog struct {
+	ID        uint `gorm:"primaryKey"`
+	JobID     uint `gorm:"index"`
+	Attempt   int
+	Message   string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+}
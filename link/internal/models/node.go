@@ -8,7 +8,9 @@ type Node struct {
 	Password  string `gorm:"type:varchar(255);not null"`
 	PublicKey string `gorm:"type:varchar(512);unique;not null"`
 	Approved  bool   `gorm:"default:false"`
+	Online    bool   `gorm:"default:false"`
 	LastSeen  time.Time
+	Connected bool      `gorm:"-"`
 	CreatedAt time.Time `gorm:"autoCreateTime"`
 	UpdatedAt time.Time `gorm:"autoUpdateTime"`
 }
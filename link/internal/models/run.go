@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Run is a single training attempt of an experiment. The experiment status
+// reflects its latest run while the run keeps the outcome of the attempt.
+type Run struct {
+	ID                 uint             `gorm:"primaryKey"`
+	ExperimentID       uint             `gorm:"uniqueIndex:idx_experiment_run"`
+	Number             int              `gorm:"uniqueIndex:idx_experiment_run"`
+	Status             ExperimentStatus `gorm:"type:varchar(32);index"`
+	Revision           int
+	PythonEnv          string `gorm:"type:varchar(64)"`
+	StartJobID         *uint
+	RunConfig          map[string]interface{} `gorm:"serializer:json;type:text"`
+	RunConfigOverrides map[string]interface{} `gorm:"serializer:json;type:text"`
+	LogDir             string
+	SuperLinkLog       string
+	FlwrLog            string
+	ExitCode           *int
+	Summary            string    `gorm:"type:text"`
+	Error              string    `gorm:"type:text"`
+	CreatedAt          time.Time `gorm:"autoCreateTime"`
+	StartedAt          *time.Time
+	FinishedAt         *time.Time
+	Nodes              []RunNode `gorm:"foreignKey:RunID"`
+}
+
+// RunNode is a node taking part in a run with its final status in that run
+type RunNode struct {
+	RunID      uint `gorm:"primaryKey"`
+	NodeID     uint `gorm:"primaryKey"`
+	MetadataID uint `gorm:"primaryKey"`
+	Status     ExperimentNodeStatus
+	Node       Node     `gorm:"foreignKey:NodeID"`
+	Metadata   Metadata `gorm:"foreignKey:MetadataID"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+type UploadStatus string
+
+const (
+	UploadStatusUploading UploadStatus = "UPLOADING"
+	UploadStatusCompleted UploadStatus = "COMPLETED"
+)
+
+// Upload is a chunked upload of an experiment archive. Parts are appended to
+// Path until Offset reaches Size and the file matches SHA256. A part being
+// written holds the claim identified by ClaimToken. HashState keeps the
+// SHA-256 of the bytes before Offset so completing needs no second read.
+type Upload struct {
+	ID         uint `gorm:"primaryKey"`
+	UserID     uint `gorm:"index"`
+	FileName   string
+	Size       int64
+	SHA256     string `gorm:"type:varchar(64)"`
+	Offset     int64
+	Status     UploadStatus `gorm:"type:varchar(32)"`
+	Path       string
+	ClaimToken string `gorm:"type:varchar(32)" json:"-"`
+	ClaimedAt  *time.Time
+	HashState  []byte    `gorm:"type:varbinary(128)" json:"-"`
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime"`
+}
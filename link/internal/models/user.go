@@ -11,4 +11,5 @@ type User struct {
 	CreatedAt time.Time `gorm:"autoCreateTime"`
 	UpdatedAt time.Time `gorm:"autoUpdateTime"`
 	Approved  bool      `gorm:"default:false"`
+	Admin     bool      `gorm:"default:false"`
 }
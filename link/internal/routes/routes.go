@@ -3,6 +3,7 @@ package routes
 import (
 	"link/internal/config"
 	"link/internal/handlers"
+	"link/internal/jobs"
 	"link/internal/middleware"
 	"link/internal/utils"
 
@@ -10,20 +11,26 @@ import (
 	"gorm.io/gorm"
 )
 
-func SetupRoutes(e *echo.Echo, db *gorm.DB, config *config.Config, pythonEnv *utils.PythonEnv) {
+// SetupRoutes registers the routes of the link. The experiment handler and
+// job runner are shared with the background workers started by the caller
+func SetupRoutes(e *echo.Echo, db *gorm.DB, config *config.Config, pythonEnv *utils.PythonEnv, signer *utils.ManifestSigner, experimentHandler *handlers.ExperimentHandler, jobRunner *jobs.Runner) {
 	e.Use(middleware.ErrorHandler)
 
-	nodeHandler := &handlers.NodeHandler{DB: db}
+	nodeHandler := &handlers.NodeHandler{DB: db, Config: config}
 	userHandler := &handlers.UserHandler{DB: db, Config: config}
-	experimentHandler := &handlers.ExperimentHandler{DB: db, Config: config, PythonEnv: pythonEnv}
 	metadataHandler := &handlers.MetadataHandler{DB: db}
-	fileHandler := &handlers.FileHandler{}
+	fileHandler := &handlers.FileHandler{DB: db}
+	keyHandler := &handlers.KeyHandler{Signer: signer}
+	uploadHandler := &handlers.UploadHandler{DB: db, Config: config}
+	wheelhouseHandler := &handlers.WheelhouseHandler{DB: db, PythonEnv: pythonEnv}
+	jobHandler := &handlers.JobHandler{DB: db, Runner: jobRunner}
 
 	// Public routes
 	e.POST("/nodes", nodeHandler.RegisterNode)
 	e.POST("/nodes/login", nodeHandler.LoginNode)
 	e.POST("/users", userHandler.RegisterUser)
 	e.POST("/users/login", userHandler.Login)
+	e.GET("/keys/manifest", keyHandler.GetManifestKey)
 
 	// Protected routes
 	r := e.Group("/api")
@@ -35,18 +42,48 @@ func SetupRoutes(e *echo.Echo, db *gorm.DB, config *config.Config, pythonEnv *ut
 	r.PUT("/nodes/status", nodeHandler.UpdateNodeStatus)
 	r.GET("/nodes", nodeHandler.ListNodes)
 	r.GET("/node/instructions", nodeHandler.PollInstructions)
+	r.POST("/node/instructions/ack", nodeHandler.AcknowledgeInstructions)
+	r.GET("/node/instructions/stream", nodeHandler.StreamInstructions)
 
 	// Experiment routes
 	r.POST("/experiments", experimentHandler.CreateExperiment)
+	r.POST("/experiments/validate", experimentHandler.ValidateExperiment)
 	r.PUT("/experiments/:experimentID/accept", experimentHandler.AcceptExperiment)
 	r.PUT("/experiments/:experimentID/reject", experimentHandler.RejectExperiment)
 	r.POST("/experiments/:id/start", experimentHandler.StartTraining)
 	r.POST("/experiments/:id/stop", experimentHandler.StopTraining)
 	r.GET("/experiments", experimentHandler.ListExperiments)
+	r.GET("/experiments/queue", experimentHandler.ListQueue)
+	r.DELETE("/experiments/queue/:entryID", experimentHandler.CancelQueueEntry)
 	r.PUT("/experiments/:id", experimentHandler.UpdateExperiment)
+	r.GET("/experiments/:id/history", experimentHandler.GetExperimentHistory)
+	r.GET("/experiments/:id/revisions", experimentHandler.ListRevisions)
+	r.GET("/experiments/:id/revisions/diff", experimentHandler.DiffRevisions)
+	r.GET("/experiments/:id/revisions/:number", experimentHandler.GetRevision)
+	r.GET("/experiments/:id/package", experimentHandler.DownloadPackage)
+	r.GET("/experiments/:id/runs", experimentHandler.ListRuns)
+	r.GET("/experiments/:id/runs/:runID", experimentHandler.GetRun)
 	r.POST("/experiments/:experimentID/node-start", experimentHandler.NodeTrainingStarted)
 	r.POST("/experiments/:experimentID/checksum", experimentHandler.ReceiveChecksum)
 	r.POST("/experiments/:experimentID/update-files", experimentHandler.UpdateFiles)
+	r.GET("/experiments/:id/requirements", wheelhouseHandler.CheckExperimentRequirements)
+
+	// Chunked upload routes
+	r.POST("/uploads", uploadHandler.CreateUpload)
+	r.GET("/uploads/:uploadID", uploadHandler.GetUpload)
+	r.PATCH("/uploads/:uploadID", uploadHandler.AppendUpload)
+	r.POST("/uploads/:uploadID/complete", uploadHandler.CompleteUpload)
+	r.DELETE("/uploads/:uploadID", uploadHandler.DeleteUpload)
+
+	// Job routes
+	r.GET("/jobs", jobHandler.ListJobs)
+	r.GET("/jobs/:id", jobHandler.GetJob)
+	r.POST("/jobs/:id/retry", jobHandler.RetryJob)
+
+	// Wheelhouse routes
+	r.GET("/wheelhouse", wheelhouseHandler.ListWheels)
+	r.POST("/wheelhouse", wheelhouseHandler.UploadWheels, middleware.RequireAdmin(db))
+	r.DELETE("/wheelhouse/:name", wheelhouseHandler.DeleteWheel, middleware.RequireAdmin(db))
 
 	// Metadata routes
 	r.POST("/metadata", metadataHandler.RegisterMetadata)
@@ -54,4 +91,5 @@ func SetupRoutes(e *echo.Echo, db *gorm.DB, config *config.Config, pythonEnv *ut
 
 	// File routes
 	r.GET("/download", fileHandler.DownloadFile)
+	r.GET("/experiments/:id/files", fileHandler.DownloadExperimentFile)
 }
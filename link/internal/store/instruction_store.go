@@ -2,8 +2,12 @@ package store
 
 import (
 	"sync"
+	"time"
 
 	"link/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type NodeInstruction struct {
@@ -11,27 +15,149 @@ type NodeInstruction struct {
 	Instruction models.Instruction
 }
 
+// InstructionStore is the database-backed queue of instructions waiting to be
+// picked up by nodes. Delivered instructions stay in the queue until the node
+// acknowledges them and are handed out again once the ack timeout expires.
 type InstructionStore struct {
-	instructions map[uint][]models.Instruction
-	mu           sync.RWMutex
+	db         *gorm.DB
+	ackTimeout time.Duration
+	waiters    map[uint]map[chan struct{}]struct{}
+	mu         sync.Mutex
 }
 
-var GlobalInstructionStore = &InstructionStore{
-	instructions: make(map[uint][]models.Instruction),
+var GlobalInstructionStore *InstructionStore
+
+// InitInstructionStore sets up the global instruction store
+func InitInstructionStore(db *gorm.DB, ackTimeout time.Duration) {
+	GlobalInstructionStore = &InstructionStore{
+		db:         db,
+		ackTimeout: ackTimeout,
+		waiters:    make(map[uint]map[chan struct{}]struct{}),
+	}
 }
 
-func (s *InstructionStore) AddInstructions(nodeInstructions []NodeInstruction) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *InstructionStore) AddInstructions(nodeInstructions []NodeInstruction) error {
+	if err := s.CreateInstructions(s.db, nodeInstructions); err != nil {
+		return err
+	}
+	s.Notify(nodeInstructions)
+	return nil
+}
+
+// CreateInstructions queues instructions in tx without waking their nodes, so
+// they are only sent when tx commits. Call Notify after the commit.
+func (s *InstructionStore) CreateInstructions(tx *gorm.DB, nodeInstructions []NodeInstruction) error {
+	if len(nodeInstructions) == 0 {
+		return nil
+	}
+
+	instructions := make([]models.Instruction, len(nodeInstructions))
+	for i, ni := range nodeInstructions {
+		instructions[i] = ni.Instruction
+		instructions[i].NodeID = ni.NodeID
+		instructions[i].Status = models.InstructionStatusPending
+	}
+
+	return tx.Create(&instructions).Error
+}
+
+// Notify wakes the nodes waiting for instructions queued by CreateInstructions
+func (s *InstructionStore) Notify(nodeInstructions []NodeInstruction) {
 	for _, ni := range nodeInstructions {
-		s.instructions[ni.NodeID] = append(s.instructions[ni.NodeID], ni.Instruction)
+		s.notify(ni.NodeID)
 	}
 }
 
-func (s *InstructionStore) GetInstructions(nodeID uint) []models.Instruction {
+// Subscribe returns a channel that is signalled whenever new instructions are
+// added for the node. The returned function must be called to unsubscribe.
+func (s *InstructionStore) Subscribe(nodeID uint) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	s.mu.Lock()
+	if s.waiters[nodeID] == nil {
+		s.waiters[nodeID] = make(map[chan struct{}]struct{})
+	}
+	s.waiters[nodeID][ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.waiters[nodeID], ch)
+		if len(s.waiters[nodeID]) == 0 {
+			delete(s.waiters, nodeID)
+		}
+	}
+}
+
+func (s *InstructionStore) notify(nodeID uint) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	instructions := s.instructions[nodeID]
-	delete(s.instructions, nodeID)
-	return instructions
+	for ch := range s.waiters[nodeID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// GetInstructions returns the pending instructions of a node together with the
+// delivered ones whose acknowledgement timed out, and marks them as delivered
+func (s *InstructionStore) GetInstructions(nodeID uint) ([]models.Instruction, error) {
+	var instructions []models.Instruction
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("node_id = ?", nodeID).
+			Where("status = ? OR (status = ? AND delivered_at < ?)",
+				models.InstructionStatusPending, models.InstructionStatusDelivered, now.Add(-s.ackTimeout)).
+			Order("id").
+			Find(&instructions).Error; err != nil {
+			return err
+		}
+
+		if len(instructions) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(instructions))
+		for i := range instructions {
+			ids[i] = instructions[i].ID
+			instructions[i].Status = models.InstructionStatusDelivered
+			instructions[i].DeliveredAt = &now
+			instructions[i].Attempts++
+		}
+
+		return tx.Model(&models.Instruction{}).
+			Where("id IN ?", ids).
+			Updates(map[string]interface{}{
+				"status":       models.InstructionStatusDelivered,
+				"delivered_at": now,
+				"attempts":     gorm.Expr("attempts + 1"),
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return instructions, nil
+}
+
+// AcknowledgeInstructions marks the given instructions of a node as handled so
+// they are no longer redelivered. It returns the number of instructions updated.
+func (s *InstructionStore) AcknowledgeInstructions(nodeID uint, ids []uint) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	result := s.db.Model(&models.Instruction{}).
+		Where("node_id = ? AND id IN ? AND status <> ?", nodeID, ids, models.InstructionStatusAcknowledged).
+		Updates(map[string]interface{}{
+			"status":   models.InstructionStatusAcknowledged,
+			"acked_at": now,
+		})
+
+	return result.RowsAffected, result.Error
 }
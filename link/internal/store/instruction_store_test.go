@@ -0,0 +1,171 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"link/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestStore(t *testing.T, ackTimeout time.Duration) *InstructionStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "link.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Instruction{}); err != nil {
+		t.Fatal(err)
+	}
+
+	InitInstructionStore(db, ackTimeout)
+	return GlobalInstructionStore
+}
+
+func addInstruction(t *testing.T, s *InstructionStore, nodeID uint, instructionType models.InstructionType) {
+	t.Helper()
+	err := s.AddInstructions([]NodeInstruction{{
+		NodeID:      nodeID,
+		Instruction: models.Instruction{Type: instructionType, Payload: map[string]interface{}{"experiment_id": 1}},
+	}})
+	if err != nil {
+		t.Fatalf("AddInstructions() error = %v", err)
+	}
+}
+
+func instructionTypes(instructions []models.Instruction) []models.InstructionType {
+	types := make([]models.InstructionType, len(instructions))
+	for i, instruction := range instructions {
+		types[i] = instruction.Type
+	}
+	return types
+}
+
+func getInstructions(t *testing.T, s *InstructionStore, nodeID uint) []models.Instruction {
+	t.Helper()
+	instructions, err := s.GetInstructions(nodeID)
+	if err != nil {
+		t.Fatalf("GetInstructions() error = %v", err)
+	}
+	return instructions
+}
+
+func TestInstructionDelivery(t *testing.T) {
+	s := newTestStore(t, time.Hour)
+	addInstruction(t, s, 1, models.InstructionNewExperiment)
+	addInstruction(t, s, 1, models.InstructionStartTraining)
+	addInstruction(t, s, 2, models.InstructionStopTraining)
+
+	first := getInstructions(t, s, 1)
+	if got := instructionTypes(first); len(got) != 2 || got[0] != models.InstructionNewExperiment || got[1] != models.InstructionStartTraining {
+		t.Fatalf("GetInstructions() = %v, want the node's instructions in order", got)
+	}
+	for _, instruction := range first {
+		if instruction.Status != models.InstructionStatusDelivered || instruction.Attempts != 1 || instruction.DeliveredAt == nil {
+			t.Fatalf("delivered instruction = %+v", instruction)
+		}
+	}
+
+	// Delivered instructions wait for their ack until the timeout expires
+	if again := getInstructions(t, s, 1); len(again) != 0 {
+		t.Fatalf("GetInstructions() redelivered %v before the ack timeout", instructionTypes(again))
+	}
+	if other := getInstructions(t, s, 2); len(other) != 1 || other[0].Type != models.InstructionStopTraining {
+		t.Fatalf("GetInstructions() for node 2 = %v", instructionTypes(other))
+	}
+}
+
+func TestInstructionRedelivery(t *testing.T) {
+	s := newTestStore(t, 50*time.Millisecond)
+	addInstruction(t, s, 1, models.InstructionNewExperiment)
+	addInstruction(t, s, 1, models.InstructionStartTraining)
+
+	delivered := getInstructions(t, s, 1)
+	if len(delivered) != 2 {
+		t.Fatalf("GetInstructions() = %v", instructionTypes(delivered))
+	}
+
+	acked, err := s.AcknowledgeInstructions(1, []uint{delivered[0].ID})
+	if err != nil || acked != 1 {
+		t.Fatalf("AcknowledgeInstructions() = %d, %v, want 1", acked, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Only the unacknowledged instruction comes back
+	redelivered := getInstructions(t, s, 1)
+	if len(redelivered) != 1 || redelivered[0].ID != delivered[1].ID || redelivered[0].Attempts != 2 {
+		t.Fatalf("GetInstructions() after the ack timeout = %+v", redelivered)
+	}
+
+	acked, err = s.AcknowledgeInstructions(1, []uint{delivered[1].ID})
+	if err != nil || acked != 1 {
+		t.Fatalf("AcknowledgeInstructions() = %d, %v, want 1", acked, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if again := getInstructions(t, s, 1); len(again) != 0 {
+		t.Fatalf("GetInstructions() redelivered acknowledged instructions %v", instructionTypes(again))
+	}
+}
+
+func TestAcknowledgeInstructions(t *testing.T) {
+	s := newTestStore(t, time.Hour)
+	addInstruction(t, s, 1, models.InstructionNewExperiment)
+	addInstruction(t, s, 2, models.InstructionNewExperiment)
+
+	own := getInstructions(t, s, 1)
+	other := getInstructions(t, s, 2)
+
+	// Nodes cannot acknowledge the instructions of other nodes
+	acked, err := s.AcknowledgeInstructions(1, []uint{other[0].ID})
+	if err != nil || acked != 0 {
+		t.Fatalf("AcknowledgeInstructions() of another node = %d, %v, want 0", acked, err)
+	}
+
+	acked, err = s.AcknowledgeInstructions(1, []uint{own[0].ID})
+	if err != nil || acked != 1 {
+		t.Fatalf("AcknowledgeInstructions() = %d, %v, want 1", acked, err)
+	}
+
+	// A repeated ack changes nothing
+	acked, err = s.AcknowledgeInstructions(1, []uint{own[0].ID})
+	if err != nil || acked != 0 {
+		t.Fatalf("repeated AcknowledgeInstructions() = %d, %v, want 0", acked, err)
+	}
+
+	var instruction models.Instruction
+	if err := s.db.First(&instruction, own[0].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if instruction.Status != models.InstructionStatusAcknowledged || instruction.AckedAt == nil {
+		t.Fatalf("acknowledged instruction = %+v", instruction)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	s := newTestStore(t, time.Hour)
+	ch, unsubscribe := s.Subscribe(1)
+
+	addInstruction(t, s, 2, models.InstructionNewExperiment)
+	select {
+	case <-ch:
+		t.Fatal("node 1 was woken by an instruction for node 2")
+	default:
+	}
+
+	addInstruction(t, s, 1, models.InstructionNewExperiment)
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("node 1 was not woken by its instruction")
+	}
+
+	unsubscribe()
+	if len(s.waiters) != 0 {
+		t.Fatalf("waiters after unsubscribe = %v", s.waiters)
+	}
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// PresenceRegistry keeps track of the nodes that currently hold an open
+// instruction stream with the link.
+type PresenceRegistry struct {
+	connections map[uint]int
+	lastBeat    map[uint]time.Time
+	mu          sync.RWMutex
+}
+
+var GlobalPresence = &PresenceRegistry{
+	connections: make(map[uint]int),
+	lastBeat:    make(map[uint]time.Time),
+}
+
+// Connect registers a stream for the node. The returned function must be
+// called once the stream is closed.
+func (p *PresenceRegistry) Connect(nodeID uint) func() {
+	p.mu.Lock()
+	p.connections[nodeID]++
+	p.lastBeat[nodeID] = time.Now()
+	p.mu.Unlock()
+
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.connections[nodeID]--
+		if p.connections[nodeID] <= 0 {
+			delete(p.connections, nodeID)
+			delete(p.lastBeat, nodeID)
+		}
+	}
+}
+
+// Heartbeat records a successful heartbeat on one of the node's streams
+func (p *PresenceRegistry) Heartbeat(nodeID uint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.connections[nodeID]; ok {
+		p.lastBeat[nodeID] = time.Now()
+	}
+}
+
+func (p *PresenceRegistry) IsConnected(nodeID uint) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.connections[nodeID]
+	return ok
+}
+
+// ConnectedNodes returns the connected nodes with the time of their last heartbeat
+func (p *PresenceRegistry) ConnectedNodes() map[uint]time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	nodes := make(map[uint]time.Time, len(p.lastBeat))
+	for nodeID, beat := range p.lastBeat {
+		nodes[nodeID] = beat
+	}
+	return nodes
+}
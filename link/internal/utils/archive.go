@@ -0,0 +1,349 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"link/internal/models"
+)
+
+// ArchiveLimits bounds what an uploaded archive may expand to. Zero values
+// disable the corresponding check.
+type ArchiveLimits struct {
+	MaxFiles     int
+	MaxTotalSize int64
+	MaxFileSize  int64
+}
+
+// ArchiveError reports an archive that was rejected because of its contents,
+// as opposed to a failure while reading or writing it
+type ArchiveError struct {
+	Message string
+}
+
+func (e *ArchiveError) Error() string {
+	return e.Message
+}
+
+func archiveErrorf(format string, args ...interface{}) error {
+	return &ArchiveError{Message: fmt.Sprintf(format, args...)}
+}
+
+// IsArchiveError reports whether err was caused by the contents of the archive
+func IsArchiveError(err error) bool {
+	var archiveErr *ArchiveError
+	return errors.As(err, &archiveErr)
+}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ArchiveSource is an uploaded archive, either a multipart file or a
+// completed chunked upload on disk
+type ArchiveSource interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// ExtractArchive extracts an uploaded .zip or .tar.gz archive of size bytes
+// into destinationDir. Entries with absolute paths or paths leaving
+// destinationDir are rejected, symlinks and device files are skipped and the
+// limits are enforced on the bytes actually written.
+func ExtractArchive(src ArchiveSource, size int64, destinationDir string, limits ArchiveLimits) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return archiveErrorf("archive is empty or truncated")
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	extractor := &archiveExtractor{destinationDir: destinationDir, limits: limits}
+	if bytes.Equal(header, gzipMagic) {
+		return extractor.extractTarGz(src)
+	}
+	return extractor.extractZip(src, size)
+}
+
+type archiveExtractor struct {
+	destinationDir string
+	limits         ArchiveLimits
+	files          int
+	totalSize      int64
+}
+
+func (x *archiveExtractor) extractZip(src io.ReaderAt, size int64) error {
+	reader, err := zip.NewReader(src, size)
+	if err != nil {
+		return archiveErrorf("failed to read zip file: %v", err)
+	}
+
+	for _, file := range reader.File {
+		mode := file.Mode()
+		if !mode.IsDir() && !mode.IsRegular() {
+			log.Printf("Skipping %s in archive: unsupported file type %s", file.Name, mode.Type())
+			continue
+		}
+
+		target, skip, err := x.entryPath(file.Name)
+		if err != nil {
+			return err
+		}
+		if skip {
+			continue
+		}
+		if mode.IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", target, err)
+			}
+			continue
+		}
+
+		if err := x.reserve(file.Name, int64(file.UncompressedSize64)); err != nil {
+			return err
+		}
+
+		srcFile, err := file.Open()
+		if err != nil {
+			return archiveErrorf("failed to open %s in archive: %v", file.Name, err)
+		}
+		err = x.writeFile(target, file.Name, srcFile, mode.Perm())
+		srcFile.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (x *archiveExtractor) extractTarGz(src io.Reader) error {
+	gzipReader, err := gzip.NewReader(bufio.NewReader(src))
+	if err != nil {
+		return archiveErrorf("failed to read gzip stream: %v", err)
+	}
+	defer gzipReader.Close()
+
+	reader := tar.NewReader(gzipReader)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return archiveErrorf("failed to read tar archive: %v", err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeReg:
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			log.Printf("Skipping %s in archive: unsupported entry type %q", header.Name, header.Typeflag)
+			continue
+		}
+
+		target, skip, err := x.entryPath(header.Name)
+		if err != nil {
+			return err
+		}
+		if skip {
+			continue
+		}
+		if header.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", target, err)
+			}
+			continue
+		}
+
+		if err := x.reserve(header.Name, header.Size); err != nil {
+			return err
+		}
+		if err := x.writeFile(target, header.Name, reader, fs.FileMode(header.Mode).Perm()); err != nil {
+			return err
+		}
+	}
+}
+
+// entryPath maps an archive entry name to its path below the destination
+// directory. Hidden files and folders are skipped.
+func (x *archiveExtractor) entryPath(name string) (string, bool, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(name) || (len(name) > 1 && name[1] == ':') {
+		return "", false, archiveErrorf("archive entry %q has an absolute path", name)
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", false, archiveErrorf("archive entry %q leaves the experiment directory", name)
+		}
+		if strings.HasPrefix(part, ".") && part != "." {
+			return "", true, nil
+		}
+	}
+
+	cleaned := path.Clean(name)
+	if cleaned == "." {
+		return "", true, nil
+	}
+
+	target := filepath.Join(x.destinationDir, filepath.FromSlash(cleaned))
+	rel, err := filepath.Rel(x.destinationDir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false, archiveErrorf("archive entry %q leaves the experiment directory", name)
+	}
+
+	return target, false, nil
+}
+
+// reserve checks the declared size of the next file against the limits
+func (x *archiveExtractor) reserve(name string, size int64) error {
+	x.files++
+	if x.limits.MaxFiles > 0 && x.files > x.limits.MaxFiles {
+		return archiveErrorf("archive contains more than %d files", x.limits.MaxFiles)
+	}
+	if x.limits.MaxFileSize > 0 && size > x.limits.MaxFileSize {
+		return archiveErrorf("%s exceeds the maximum file size of %d bytes", name, x.limits.MaxFileSize)
+	}
+	if x.limits.MaxTotalSize > 0 && x.totalSize+size > x.limits.MaxTotalSize {
+		return archiveErrorf("archive exceeds the maximum extracted size of %d bytes", x.limits.MaxTotalSize)
+	}
+	return nil
+}
+
+// writeFile copies an entry to target without trusting its declared size
+func (x *archiveExtractor) writeFile(target, name string, src io.Reader, perm fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory structure: %w", err)
+	}
+
+	// Never follow a link that might already sit at the target
+	if info, err := os.Lstat(target); err == nil && !info.Mode().IsRegular() {
+		return archiveErrorf("archive entry %q conflicts with an existing entry", name)
+	}
+
+	dstFile, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm|0600)
+	if err != nil {
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer dstFile.Close()
+
+	limit := int64(-1)
+	if x.limits.MaxFileSize > 0 {
+		limit = x.limits.MaxFileSize
+	}
+	if x.limits.MaxTotalSize > 0 && (limit < 0 || x.limits.MaxTotalSize-x.totalSize < limit) {
+		limit = x.limits.MaxTotalSize - x.totalSize
+	}
+
+	if limit >= 0 {
+		src = io.LimitReader(src, limit+1)
+	}
+	written, err := io.Copy(dstFile, src)
+	if err != nil {
+		return archiveErrorf("failed to extract %s: %v", name, err)
+	}
+	if limit >= 0 && written > limit {
+		return archiveErrorf("%s expands beyond the archive size limits", name)
+	}
+
+	x.totalSize += written
+	return nil
+}
+
+// packageModTime is the fixed modification time of every packaged file so
+// the same manifest always produces the same archive bytes
+var packageModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// WritePackageArchive writes the files of a manifest, read from dir, as a
+// deterministic .zip or .tar.gz archive with every path below prefix
+func WritePackageArchive(w io.Writer, dir, prefix string, manifest []models.ManifestEntry, format string) error {
+	switch format {
+	case "zip":
+		return writePackageZip(w, dir, prefix, manifest)
+	case "tar.gz":
+		return writePackageTarGz(w, dir, prefix, manifest)
+	}
+	return fmt.Errorf("unsupported archive format %q", format)
+}
+
+func writePackageZip(w io.Writer, dir, prefix string, manifest []models.ManifestEntry) error {
+	zipWriter := zip.NewWriter(w)
+	for _, entry := range manifest {
+		header := &zip.FileHeader{
+			Name:     path.Join(prefix, entry.Path),
+			Method:   zip.Deflate,
+			Modified: packageModTime,
+		}
+		header.SetMode(0644)
+
+		dst, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if err := copyManifestEntry(dst, dir, entry); err != nil {
+			return err
+		}
+	}
+	return zipWriter.Close()
+}
+
+func writePackageTarGz(w io.Writer, dir, prefix string, manifest []models.ManifestEntry) error {
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, entry := range manifest {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(prefix, entry.Path),
+			Size:     entry.Size,
+			Mode:     0644,
+			ModTime:  packageModTime,
+			Format:   tar.FormatPAX,
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := copyManifestEntry(tarWriter, dir, entry); err != nil {
+			return err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// copyManifestEntry copies a file and fails if it no longer matches its
+// manifest entry
+func copyManifestEntry(dst io.Writer, dir string, entry models.ManifestEntry) error {
+	file, err := os.Open(filepath.Join(dir, filepath.FromSlash(entry.Path)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(dst, hasher), io.LimitReader(file, entry.Size+1))
+	if err != nil {
+		return err
+	}
+	if written != entry.Size || fmt.Sprintf("%x", hasher.Sum(nil)) != entry.SHA256 {
+		return fmt.Errorf("%s does not match the revision manifest", entry.Path)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// archiveEntry is a file, directory or symlink written into a test archive
+type archiveEntry struct {
+	name    string
+	content string
+	dir     bool
+	symlink string
+}
+
+func buildZip(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		switch {
+		case entry.dir:
+			header.SetMode(fs.ModeDir | 0755)
+		case entry.symlink != "":
+			header.SetMode(fs.ModeSymlink | 0777)
+		default:
+			header.SetMode(0644)
+		}
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := entry.content
+		if entry.symlink != "" {
+			content = entry.symlink
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func buildTarGz(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	writer := tar.NewWriter(gzipWriter)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.content))}
+		switch {
+		case entry.dir:
+			header = &tar.Header{Name: entry.name, Mode: 0755, Typeflag: tar.TypeDir}
+		case entry.symlink != "":
+			header = &tar.Header{Name: entry.name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: entry.symlink}
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := writer.Write([]byte(entry.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extractedFiles lists the regular files below dir with their contents
+func extractedFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			t.Errorf("symlink %s was extracted", path)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestExtractArchive(t *testing.T) {
+	app := []archiveEntry{
+		{name: "app/", dir: true},
+		{name: "app/pyproject.toml", content: "[project]\n"},
+		{name: "app/app/client_app.py", content: "client"},
+	}
+	appFiles := map[string]string{
+		"app/pyproject.toml":    "[project]\n",
+		"app/app/client_app.py": "client",
+	}
+
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		limits  ArchiveLimits
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "app", entries: app, want: appFiles},
+		{
+			name:    "hidden files are skipped",
+			entries: append([]archiveEntry{{name: "app/.env", content: "SECRET=1"}, {name: ".git/HEAD", content: "ref"}}, app...),
+			want:    appFiles,
+		},
+		{
+			name:    "symlinks are skipped",
+			entries: append([]archiveEntry{{name: "app/passwd", symlink: "/etc/passwd"}}, app...),
+			want:    appFiles,
+		},
+		{
+			name:    "backslashes are separators",
+			entries: []archiveEntry{{name: `app\app\server_app.py`, content: "server"}},
+			want:    map[string]string{"app/app/server_app.py": "server"},
+		},
+		{name: "parent directory", entries: []archiveEntry{{name: "../evil.py", content: "x"}}, wantErr: "leaves the experiment directory"},
+		{name: "nested parent directory", entries: []archiveEntry{{name: "app/../../evil.py", content: "x"}}, wantErr: "leaves the experiment directory"},
+		{name: "absolute path", entries: []archiveEntry{{name: "/etc/evil.py", content: "x"}}, wantErr: "absolute path"},
+		{name: "drive letter", entries: []archiveEntry{{name: "C:/evil.py", content: "x"}}, wantErr: "absolute path"},
+		{name: "too many files", entries: app, limits: ArchiveLimits{MaxFiles: 1}, wantErr: "more than 1 files"},
+		{name: "file too large", entries: app, limits: ArchiveLimits{MaxFileSize: 8}, wantErr: "maximum file size"},
+		{name: "archive too large", entries: app, limits: ArchiveLimits{MaxTotalSize: 12}, wantErr: "maximum extracted size"},
+		{name: "within limits", entries: app, limits: ArchiveLimits{MaxFiles: 2, MaxFileSize: 10, MaxTotalSize: 16}, want: appFiles},
+	}
+
+	formats := []struct {
+		name  string
+		build func(*testing.T, []archiveEntry) []byte
+	}{
+		{"zip", buildZip},
+		{"tar.gz", buildTarGz},
+	}
+
+	for _, format := range formats {
+		for _, tt := range tests {
+			t.Run(format.name+"/"+tt.name, func(t *testing.T) {
+				data := format.build(t, tt.entries)
+				dir := t.TempDir()
+
+				err := ExtractArchive(bytes.NewReader(data), int64(len(data)), dir, tt.limits)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("ExtractArchive() error = %v, want %q", err, tt.wantErr)
+					}
+					if !IsArchiveError(err) {
+						t.Fatalf("ExtractArchive() error = %v is not an ArchiveError", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("ExtractArchive() error = %v", err)
+				}
+
+				got := extractedFiles(t, dir)
+				if len(got) != len(tt.want) {
+					t.Fatalf("extracted %v, want %v", sortedKeys(got), sortedKeys(tt.want))
+				}
+				for name, content := range tt.want {
+					if got[name] != content {
+						t.Fatalf("%s = %q, want %q", name, got[name], content)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestExtractArchiveRejectsInvalidData(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "not an archive", data: []byte("not an archive")},
+		{name: "truncated gzip", data: buildTarGz(t, []archiveEntry{{name: "a.py", content: "x"}})[:12]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ExtractArchive(bytes.NewReader(tt.data), int64(len(tt.data)), t.TempDir(), ArchiveLimits{})
+			if !IsArchiveError(err) {
+				t.Fatalf("ExtractArchive() error = %v, want an ArchiveError", err)
+			}
+		})
+	}
+}
+
+func sortedKeys(files map[string]string) []string {
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -7,6 +7,13 @@ import (
 type AppError struct {
 	StatusCode int
 	Message    string
+	Fields     []FieldError
+}
+
+// FieldError describes a problem with a single field of a request or file
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 func (e *AppError) Error() string {
@@ -40,3 +47,18 @@ func NewUnauthorizedError(message string) *AppError {
 		Message:    message,
 	}
 }
+
+func NewForbiddenError(message string) *AppError {
+	return &AppError{
+		StatusCode: http.StatusForbidden,
+		Message:    message,
+	}
+}
+
+func NewValidationError(message string, fields []FieldError) *AppError {
+	return &AppError{
+		StatusCode: http.StatusUnprocessableEntity,
+		Message:    message,
+		Fields:     fields,
+	}
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+)
+
+const (
+	caCertFile     = "authentication/certificates/ca.crt"
+	serverCertFile = "authentication/certificates/server.pem"
+	serverKeyFile  = "authentication/certificates/server.key"
+)
+
+// Federation holds the SuperLink and flwr processes of a single experiment
+// together with the ports and files reserved for it
+type Federation struct {
+	ExperimentID    string
+	Slot            int
+	FleetPort       int
+	ExecPort        int
+	ServerAppIoPort int // formerly the Driver API
+	KeysFile        string
+	LogDir          string
+	SuperLinkLog    string
+	FlwrLog         string
+	Env             *VirtualEnv
+	SuperLink       Process
+	Flwr            Process
+	// Ready is set by the link once nodes were told to connect. Until then
+	// the start of the experiment handles an exit of the SuperLink.
+	Ready bool
+
+	superLinkExit *processExit
+}
+
+// SuperLinkExited reports whether the SuperLink has exited and with which code
+func (f *Federation) SuperLinkExited() (int, bool) {
+	if f.superLinkExit == nil {
+		return 0, false
+	}
+	return f.superLinkExit.exited()
+}
+
+func (f *Federation) FleetAddress() string {
+	return fmt.Sprintf("127.0.0.1:%d", f.FleetPort)
+}
+
+func (f *Federation) ExecAddress() string {
+	return fmt.Sprintf("127.0.0.1:%d", f.ExecPort)
+}
+
+// FederationConfig returns the --federation-config overrides that point flwr
+// run at this federation's Exec API regardless of the pyproject.toml contents
+func (f *Federation) FederationConfig() (string, error) {
+	rootCertificates, err := filepath.Abs(caCertFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve CA certificate path: %v", err)
+	}
+
+	return fmt.Sprintf("address='%s' root-certificates='%s'", f.ExecAddress(), rootCertificates), nil
+}
+
+// NormalizePyProject points the federation named after the app in the
+// pyproject.toml at appDir at the link's Exec API and CA certificate. The
+// address is the one of the first federation slot; runs started by the link
+// override it with the ports of the federation they actually get.
+func (env *PythonEnv) NormalizePyProject(appDir, appName string) (bool, error) {
+	rootCertificates, err := filepath.Abs(caCertFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve CA certificate path: %v", err)
+	}
+
+	env.federationsMu.Lock()
+	address := fmt.Sprintf("127.0.0.1:%d", env.portRangeStart+1)
+	env.federationsMu.Unlock()
+
+	return NormalizeFederation(filepath.Join(appDir, "pyproject.toml"), appName, address, rootCertificates)
+}
+
+// ConfigureFederations sets the port range and the maximum number of
+// federations that may run at the same time. Slot n uses the three ports
+// starting at portRangeStart + 3n.
+func (env *PythonEnv) ConfigureFederations(portRangeStart, maxFederations int) {
+	env.federationsMu.Lock()
+	defer env.federationsMu.Unlock()
+	env.portRangeStart = portRangeStart
+	env.maxFederations = maxFederations
+}
+
+// GetFederation returns the running federation of an experiment, if any
+func (env *PythonEnv) GetFederation(experimentID string) (*Federation, bool) {
+	env.federationsMu.Lock()
+	defer env.federationsMu.Unlock()
+	federation, ok := env.federations[experimentID]
+	return federation, ok
+}
+
+// ActiveFederations returns the number of federations currently reserved
+func (env *PythonEnv) ActiveFederations() int {
+	env.federationsMu.Lock()
+	defer env.federationsMu.Unlock()
+	return len(env.federations)
+}
+
+// HasFreeFederationSlot reports whether another experiment may be started
+func (env *PythonEnv) HasFreeFederationSlot() bool {
+	return env.ActiveFederations() < env.maxFederations
+}
+
+// ReserveFederation allocates a free slot with unused ports for an experiment,
+// logging to logDir. The slot counts against the concurrency limit until
+// StopFederation releases it.
+func (env *PythonEnv) ReserveFederation(experimentID, logDir string) (*Federation, error) {
+	env.federationsMu.Lock()
+	defer env.federationsMu.Unlock()
+
+	if _, ok := env.federations[experimentID]; ok {
+		return nil, fmt.Errorf("experiment %s already has a running federation", experimentID)
+	}
+
+	used := make(map[int]bool, len(env.federations))
+	for _, federation := range env.federations {
+		used[federation.Slot] = true
+	}
+
+	for slot := 0; slot < env.maxFederations; slot++ {
+		if used[slot] {
+			continue
+		}
+
+		base := env.portRangeStart + slot*3
+		if !portsAvailable(base, base+1, base+2) {
+			continue
+		}
+
+		federation := &Federation{
+			ExperimentID:    experimentID,
+			Slot:            slot,
+			FleetPort:       base,
+			ExecPort:        base + 1,
+			ServerAppIoPort: base + 2,
+			KeysFile:        filepath.Join("authentication", "keys", "experiments", experimentID, "client_public_keys.csv"),
+			LogDir:          logDir,
+		}
+		env.federations[experimentID] = federation
+		return federation, nil
+	}
+
+	return nil, fmt.Errorf("no free federation slot available")
+}
+
+func (env *PythonEnv) releaseFederation(experimentID string) {
+	env.federationsMu.Lock()
+	defer env.federationsMu.Unlock()
+	delete(env.federations, experimentID)
+}
+
+func portsAvailable(ports ...int) bool {
+	for _, port := range ports {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			return false
+		}
+		listener.Close()
+	}
+	return true
+}
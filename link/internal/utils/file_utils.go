@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"archive/zip"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -37,76 +36,55 @@ func SaveUploadedFile(file *multipart.FileHeader, directory string) (string, err
 	return path, nil
 }
 
-func ExtractZipFile(zipFile *multipart.FileHeader, destinationDir string) error {
-	src, err := zipFile.Open()
+// CopyFile copies the regular file at src to dst, creating missing directories
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
 	if err != nil {
-		return fmt.Errorf("failed to open zip file: %w", err)
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
 	}
-	defer src.Close()
 
-	tempZipPath := filepath.Join(destinationDir, "temp.zip")
-	dst, err := os.Create(tempZipPath)
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to create temporary zip file: %w", err)
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("failed to copy file contents: %w", err)
+	}
+	return nil
+}
+
+// ResolveWithin joins the relative path rel to root and makes sure the result,
+// with symlinks followed, stays inside root
+func ResolveWithin(root, rel string) (string, error) {
+	if rel == "" || filepath.IsAbs(rel) || strings.HasPrefix(rel, "/") || strings.Contains(rel, "\\") {
+		return "", fmt.Errorf("invalid path %q", rel)
 	}
-	defer os.Remove(tempZipPath)
-	defer dst.Close()
 
-	// Copy zip content to temp file
-	if _, err := io.Copy(dst, src); err != nil {
-		return fmt.Errorf("failed to save zip file: %w", err)
+	cleaned := filepath.Clean(filepath.FromSlash(rel))
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q leaves the experiment directory", rel)
 	}
-	dst.Close()
 
-	reader, err := zip.OpenReader(tempZipPath)
+	realRoot, err := filepath.EvalSymlinks(root)
 	if err != nil {
-		return fmt.Errorf("failed to read zip file: %w", err)
+		return "", fmt.Errorf("failed to resolve experiment directory: %w", err)
 	}
-	defer reader.Close()
-
-	// Extract files
-	for _, file := range reader.File {
-		if strings.HasPrefix(filepath.Base(file.Name), ".") {
-			continue
-		}
-
-		// Create full path for extracted file
-		path := filepath.Join(destinationDir, file.Name)
-
-		if file.FileInfo().IsDir() {
-			// Create directory
-			if err := os.MkdirAll(path, file.Mode()); err != nil {
-				return fmt.Errorf("failed to create directory %s: %w", path, err)
-			}
-			continue
-		}
-
-		// Ensure the directory exists
-		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-			return fmt.Errorf("failed to create directory structure: %w", err)
-		}
-
-		// Extract the file
-		dstFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-		if err != nil {
-			return fmt.Errorf("failed to create destination file: %w", err)
-		}
-
-		srcFile, err := file.Open()
-		if err != nil {
-			dstFile.Close()
-			return fmt.Errorf("failed to open source file: %w", err)
-		}
-
-		if _, err := io.Copy(dstFile, srcFile); err != nil {
-			dstFile.Close()
-			srcFile.Close()
-			return fmt.Errorf("failed to copy file contents: %w", err)
-		}
-
-		dstFile.Close()
-		srcFile.Close()
+	realPath, err := filepath.EvalSymlinks(filepath.Join(realRoot, cleaned))
+	if err != nil {
+		return "", err
 	}
 
-	return nil
+	inside, err := filepath.Rel(realRoot, realPath)
+	if err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q leaves the experiment directory", rel)
+	}
+
+	return realPath, nil
 }
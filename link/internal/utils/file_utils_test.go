@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveWithin(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	mustWriteFile(t, filepath.Join(root, "app", "task.py"), "print()")
+	mustWriteFile(t, filepath.Join(outside, "secret.txt"), "secret")
+	mustSymlink(t, filepath.Join(root, "app"), filepath.Join(root, "inner"))
+	mustSymlink(t, outside, filepath.Join(root, "escape"))
+
+	tests := []struct {
+		name     string
+		rel      string
+		want     string
+		notExist bool
+		wantErr  bool
+	}{
+		{name: "file", rel: "app/task.py", want: "app/task.py"},
+		{name: "dot segments inside", rel: "app/../app/./task.py", want: "app/task.py"},
+		{name: "directory", rel: "app", want: "app"},
+		{name: "symlink inside root", rel: "inner/task.py", want: "app/task.py"},
+		{name: "empty", rel: "", wantErr: true},
+		{name: "root itself", rel: ".", wantErr: true},
+		{name: "absolute", rel: "/etc/passwd", wantErr: true},
+		{name: "backslash", rel: `app\task.py`, wantErr: true},
+		{name: "parent", rel: "..", wantErr: true},
+		{name: "leaves root", rel: "../secret.txt", wantErr: true},
+		{name: "leaves root after cleaning", rel: "app/../../secret.txt", wantErr: true},
+		{name: "symlink leaving root", rel: "escape/secret.txt", wantErr: true},
+		{name: "missing", rel: "app/missing.py", notExist: true},
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveWithin(root, tt.rel)
+			switch {
+			case tt.notExist:
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Fatalf("ResolveWithin(%q) error = %v, want fs.ErrNotExist", tt.rel, err)
+				}
+			case tt.wantErr:
+				if err == nil {
+					t.Fatalf("ResolveWithin(%q) = %q, want an error", tt.rel, got)
+				}
+				if errors.Is(err, fs.ErrNotExist) {
+					t.Fatalf("ResolveWithin(%q) error = %v, want a rejected path", tt.rel, err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("ResolveWithin(%q) error = %v", tt.rel, err)
+				}
+				if want := filepath.Join(realRoot, filepath.FromSlash(tt.want)); got != want {
+					t.Fatalf("ResolveWithin(%q) = %q, want %q", tt.rel, got, want)
+				}
+			}
+		})
+	}
+}
+
+func mustSymlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks are not supported: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxSummarySize bounds the part of a flwr log kept as the summary of a run
+const maxSummarySize = 64 << 10
+
+// ReadRunSummary returns the summary the ServerApp prints at the end of a run,
+// starting at its [SUMMARY] line. It returns an empty string when the run did
+// not get that far.
+func ReadRunSummary(logFile string) (string, error) {
+	file, err := os.Open(logFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to open flwr log file: %v", err)
+	}
+	defer file.Close()
+
+	var summary strings.Builder
+	inSummary := false
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "[SUMMARY]") {
+			// A later summary replaces an earlier one
+			summary.Reset()
+			inSummary = true
+		}
+		if inSummary && summary.Len()+len(line) < maxSummarySize {
+			summary.WriteString(line)
+			summary.WriteByte('\n')
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read flwr log file: %v", err)
+	}
+
+	return summary.String(), nil
+}
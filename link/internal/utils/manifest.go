@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"link/internal/models"
+)
+
+// BuildManifest hashes every regular file below dir. Hidden files and Python
+// caches are left out as they are never part of an uploaded package.
+func BuildManifest(dir string) ([]models.ManifestEntry, error) {
+	var manifest []models.ManifestEntry
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && (strings.HasPrefix(d.Name(), ".") || d.Name() == "__pycache__") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hash, size, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+
+		manifest = append(manifest, models.ManifestEntry{
+			Path:   filepath.ToSlash(rel),
+			Size:   size,
+			SHA256: hash,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build manifest: %v", err)
+	}
+
+	sort.Slice(manifest, func(i, j int) bool { return manifest[i].Path < manifest[j].Path })
+	return manifest, nil
+}
+
+func hashFile(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), size, nil
+}
+
+// ManifestHash returns the SHA-256 of the manifest, one
+// "<sha256> <size> <path>" line per file in path order
+func ManifestHash(manifest []models.ManifestEntry) string {
+	entries := make([]models.ManifestEntry, len(manifest))
+	copy(entries, manifest)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	hasher := sha256.New()
+	for _, entry := range entries {
+		fmt.Fprintf(hasher, "%s %d %s\n", entry.SHA256, entry.Size, entry.Path)
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
+// ManifestDiff lists the files that differ between two manifests
+type ManifestDiff struct {
+	Added    []models.ManifestEntry `json:"added"`
+	Removed  []models.ManifestEntry `json:"removed"`
+	Modified []models.ManifestEntry `json:"modified"`
+}
+
+// Paths returns every path touched by the diff
+func (d ManifestDiff) Paths() []string {
+	var paths []string
+	for _, group := range [][]models.ManifestEntry{d.Added, d.Removed, d.Modified} {
+		for _, entry := range group {
+			paths = append(paths, entry.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// DiffManifests compares two manifests. Modified entries carry the values of to.
+func DiffManifests(from, to []models.ManifestEntry) ManifestDiff {
+	diff := ManifestDiff{
+		Added:    []models.ManifestEntry{},
+		Removed:  []models.ManifestEntry{},
+		Modified: []models.ManifestEntry{},
+	}
+
+	previous := make(map[string]models.ManifestEntry, len(from))
+	for _, entry := range from {
+		previous[entry.Path] = entry
+	}
+
+	for _, entry := range to {
+		old, ok := previous[entry.Path]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, entry)
+		case old.SHA256 != entry.SHA256 || old.Size != entry.Size:
+			diff.Modified = append(diff.Modified, entry)
+		}
+		delete(previous, entry.Path)
+	}
+	for _, entry := range from {
+		if _, ok := previous[entry.Path]; ok {
+			diff.Removed = append(diff.Removed, entry)
+		}
+	}
+
+	return diff
+}
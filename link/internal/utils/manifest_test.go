@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"link/internal/models"
+)
+
+func manifestEntry(path, sha256 string, size int64) models.ManifestEntry {
+	return models.ManifestEntry{Path: path, SHA256: sha256, Size: size}
+}
+
+func sha256Hex(content string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+}
+
+func TestDiffManifests(t *testing.T) {
+	tests := []struct {
+		name      string
+		from, to  []models.ManifestEntry
+		want      ManifestDiff
+		wantPaths []string
+	}{
+		{
+			name: "empty",
+			want: ManifestDiff{Added: []models.ManifestEntry{}, Removed: []models.ManifestEntry{}, Modified: []models.ManifestEntry{}},
+		},
+		{
+			name: "unchanged",
+			from: []models.ManifestEntry{manifestEntry("a.py", "aa", 1)},
+			to:   []models.ManifestEntry{manifestEntry("a.py", "aa", 1)},
+			want: ManifestDiff{Added: []models.ManifestEntry{}, Removed: []models.ManifestEntry{}, Modified: []models.ManifestEntry{}},
+		},
+		{
+			name:      "added",
+			from:      []models.ManifestEntry{manifestEntry("a.py", "aa", 1)},
+			to:        []models.ManifestEntry{manifestEntry("a.py", "aa", 1), manifestEntry("b.py", "bb", 2)},
+			want:      ManifestDiff{Added: []models.ManifestEntry{manifestEntry("b.py", "bb", 2)}, Removed: []models.ManifestEntry{}, Modified: []models.ManifestEntry{}},
+			wantPaths: []string{"b.py"},
+		},
+		{
+			name:      "removed",
+			from:      []models.ManifestEntry{manifestEntry("a.py", "aa", 1), manifestEntry("b.py", "bb", 2)},
+			to:        []models.ManifestEntry{manifestEntry("b.py", "bb", 2)},
+			want:      ManifestDiff{Added: []models.ManifestEntry{}, Removed: []models.ManifestEntry{manifestEntry("a.py", "aa", 1)}, Modified: []models.ManifestEntry{}},
+			wantPaths: []string{"a.py"},
+		},
+		{
+			name:      "modified content carries the new entry",
+			from:      []models.ManifestEntry{manifestEntry("a.py", "aa", 1)},
+			to:        []models.ManifestEntry{manifestEntry("a.py", "a2", 1)},
+			want:      ManifestDiff{Added: []models.ManifestEntry{}, Removed: []models.ManifestEntry{}, Modified: []models.ManifestEntry{manifestEntry("a.py", "a2", 1)}},
+			wantPaths: []string{"a.py"},
+		},
+		{
+			name:      "modified size",
+			from:      []models.ManifestEntry{manifestEntry("a.py", "aa", 1)},
+			to:        []models.ManifestEntry{manifestEntry("a.py", "aa", 3)},
+			want:      ManifestDiff{Added: []models.ManifestEntry{}, Removed: []models.ManifestEntry{}, Modified: []models.ManifestEntry{manifestEntry("a.py", "aa", 3)}},
+			wantPaths: []string{"a.py"},
+		},
+		{
+			name: "renamed file is removed and added",
+			from: []models.ManifestEntry{manifestEntry("c.py", "cc", 1), manifestEntry("old.py", "xx", 4)},
+			to:   []models.ManifestEntry{manifestEntry("new.py", "xx", 4), manifestEntry("c.py", "c2", 2)},
+			want: ManifestDiff{
+				Added:    []models.ManifestEntry{manifestEntry("new.py", "xx", 4)},
+				Removed:  []models.ManifestEntry{manifestEntry("old.py", "xx", 4)},
+				Modified: []models.ManifestEntry{manifestEntry("c.py", "c2", 2)},
+			},
+			wantPaths: []string{"c.py", "new.py", "old.py"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffManifests(tt.from, tt.to)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("DiffManifests() = %+v, want %+v", got, tt.want)
+			}
+			if paths := got.Paths(); !reflect.DeepEqual(paths, tt.wantPaths) {
+				t.Fatalf("Paths() = %v, want %v", paths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestManifestHash(t *testing.T) {
+	base := []models.ManifestEntry{manifestEntry("a.py", "aa", 1), manifestEntry("b/c.py", "cc", 2)}
+	baseHash := ManifestHash(base)
+
+	tests := []struct {
+		name     string
+		manifest []models.ManifestEntry
+		same     bool
+	}{
+		{name: "same entries", manifest: []models.ManifestEntry{manifestEntry("a.py", "aa", 1), manifestEntry("b/c.py", "cc", 2)}, same: true},
+		{name: "other order", manifest: []models.ManifestEntry{manifestEntry("b/c.py", "cc", 2), manifestEntry("a.py", "aa", 1)}, same: true},
+		{name: "other content", manifest: []models.ManifestEntry{manifestEntry("a.py", "a2", 1), manifestEntry("b/c.py", "cc", 2)}},
+		{name: "other size", manifest: []models.ManifestEntry{manifestEntry("a.py", "aa", 5), manifestEntry("b/c.py", "cc", 2)}},
+		{name: "other path", manifest: []models.ManifestEntry{manifestEntry("a2.py", "aa", 1), manifestEntry("b/c.py", "cc", 2)}},
+		{name: "missing entry", manifest: []models.ManifestEntry{manifestEntry("a.py", "aa", 1)}},
+		{name: "empty", manifest: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ManifestHash(tt.manifest); (got == baseHash) != tt.same {
+				t.Fatalf("ManifestHash() = %s, base %s, want same = %v", got, baseHash, tt.same)
+			}
+		})
+	}
+
+	if ManifestHash(base) != baseHash || base[0].Path != "a.py" {
+		t.Fatal("ManifestHash() changed its input or is not deterministic")
+	}
+}
+
+func TestBuildManifest(t *testing.T) {
+	dir := t.TempDir()
+	mustWriteFile(t, filepath.Join(dir, "pyproject.toml"), "[project]\n")
+	mustWriteFile(t, filepath.Join(dir, "app", "task.py"), "x")
+	mustWriteFile(t, filepath.Join(dir, "app", "__pycache__", "task.pyc"), "cache")
+	mustWriteFile(t, filepath.Join(dir, ".git", "HEAD"), "ref")
+	mustWriteFile(t, filepath.Join(dir, "app", ".env"), "SECRET=1")
+
+	manifest, err := BuildManifest(dir)
+	if err != nil {
+		t.Fatalf("BuildManifest() error = %v", err)
+	}
+
+	want := []models.ManifestEntry{
+		manifestEntry("app/task.py", sha256Hex("x"), 1),
+		manifestEntry("pyproject.toml", sha256Hex("[project]\n"), 10),
+	}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Fatalf("BuildManifest() = %+v, want %+v", manifest, want)
+	}
+}
+
+func mustWriteFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,298 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// PyProject holds the parts of a Flower app's pyproject.toml used by the link
+type PyProject struct {
+	Project struct {
+		Name         string   `toml:"name"`
+		Version      string   `toml:"version"`
+		Dependencies []string `toml:"dependencies"`
+	} `toml:"project"`
+	Tool struct {
+		Flwr struct {
+			App struct {
+				Publisher  string `toml:"publisher"`
+				Components struct {
+					ServerApp string `toml:"serverapp"`
+					ClientApp string `toml:"clientapp"`
+				} `toml:"components"`
+				Config map[string]interface{} `toml:"config"`
+			} `toml:"app"`
+			Federations map[string]interface{} `toml:"federations"`
+		} `toml:"flwr"`
+	} `toml:"tool"`
+}
+
+// LoadPyProject parses the pyproject.toml at path
+func LoadPyProject(path string) (*PyProject, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pyproject.toml: %v", err)
+	}
+
+	return ParsePyProject(data)
+}
+
+func ParsePyProject(data []byte) (*PyProject, error) {
+	var project PyProject
+	if err := toml.Unmarshal(data, &project); err != nil {
+		return nil, fmt.Errorf("failed to parse pyproject.toml: %v", err)
+	}
+
+	return &project, nil
+}
+
+// Validate checks the pyproject.toml of the Flower app in appDir, whose folder
+// name is appName, and returns one error per invalid field
+func (p *PyProject) Validate(appDir, appName string) []FieldError {
+	var problems []FieldError
+
+	if p.Project.Name == "" {
+		problems = append(problems, FieldError{Field: "project.name", Message: "is required"})
+	} else if NormalizeProjectName(p.Project.Name) != NormalizeProjectName(appName) {
+		problems = append(problems, FieldError{
+			Field:   "project.name",
+			Message: fmt.Sprintf("must match the app folder name %q, got %q", appName, p.Project.Name),
+		})
+	}
+
+	components := map[string]string{
+		"serverapp": p.Tool.Flwr.App.Components.ServerApp,
+		"clientapp": p.Tool.Flwr.App.Components.ClientApp,
+	}
+	for _, name := range []string{"serverapp", "clientapp"} {
+		field := "tool.flwr.app.components." + name
+		if message := validateComponent(appDir, components[name]); message != "" {
+			problems = append(problems, FieldError{Field: field, Message: message})
+		}
+	}
+
+	federation := "tool.flwr.federations." + tomlKey(appName)
+	if _, ok := p.Tool.Flwr.Federations[appName].(map[string]interface{}); !ok {
+		problems = append(problems, FieldError{
+			Field:   federation,
+			Message: fmt.Sprintf("a federation named after the app folder %q is required", appName),
+		})
+	}
+
+	for key, value := range p.Tool.Flwr.App.Config {
+		switch value.(type) {
+		case int64, float64, bool, string:
+		default:
+			problems = append(problems, FieldError{
+				Field:   "tool.flwr.app.config." + key,
+				Message: "must be an integer, float, boolean or string",
+			})
+		}
+	}
+
+	return problems
+}
+
+// projectNameSeparators are the runs of characters PEP 503 treats as equal
+var projectNameSeparators = regexp.MustCompile(`[-_.]+`)
+
+// NormalizeProjectName normalizes a Python project name as described in PEP
+// 503, so "my-app", "My_App" and "my.app" are the same project
+func NormalizeProjectName(name string) string {
+	return strings.ToLower(projectNameSeparators.ReplaceAllString(name, "-"))
+}
+
+// bareKey matches the keys TOML allows without quotes
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tomlKey formats key for a TOML table header. Keys that are not bare keys,
+// such as app names containing dots, are quoted so they name a single table.
+func tomlKey(key string) string {
+	if bareKey.MatchString(key) {
+		return key
+	}
+	return strconv.Quote(key)
+}
+
+// validateComponent checks that a component reference of the form
+// "package.module:attribute" points to a module inside the app
+func validateComponent(appDir, reference string) string {
+	if reference == "" {
+		return "is required"
+	}
+
+	module, attribute, ok := strings.Cut(reference, ":")
+	if !ok || module == "" || attribute == "" {
+		return fmt.Sprintf("must have the form \"package.module:attribute\", got %q", reference)
+	}
+
+	modulePath := filepath.Join(appDir, filepath.FromSlash(strings.ReplaceAll(module, ".", "/"))+".py")
+	if _, err := os.Stat(modulePath); err != nil {
+		return fmt.Sprintf("module %q not found in the experiment package", module)
+	}
+
+	return ""
+}
+
+// NormalizeFederation rewrites the address and root-certificates of the
+// [tool.flwr.federations.<federation>] table in the pyproject.toml at path,
+// keeping the rest of the file untouched. It reports whether the file changed.
+func NormalizeFederation(path, federation, address, rootCertificates string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read pyproject.toml: %v", err)
+	}
+
+	values := map[string]string{
+		"address":           strconv.Quote(address),
+		"root-certificates": strconv.Quote(rootCertificates),
+	}
+	// A bare key may be quoted in the file as well
+	headers := map[string]bool{
+		fmt.Sprintf("[tool.flwr.federations.%s]", tomlKey(federation)):       true,
+		fmt.Sprintf("[tool.flwr.federations.%s]", strconv.Quote(federation)): true,
+	}
+
+	lines := strings.Split(string(data), "\n")
+	var normalized []string
+	inTable := false
+	seen := map[string]bool{}
+
+	flush := func() {
+		for _, key := range []string{"address", "root-certificates"} {
+			if !seen[key] {
+				normalized = append(normalized, fmt.Sprintf("%s = %s", key, values[key]))
+			}
+		}
+	}
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			if inTable {
+				flush()
+			}
+			inTable = headers[trimmed]
+		} else if inTable {
+			key, _, found := strings.Cut(trimmed, "=")
+			key = strings.TrimSpace(key)
+			if _, ok := values[key]; found && ok {
+				line = fmt.Sprintf("%s = %s", key, values[key])
+				seen[key] = true
+			}
+		}
+		normalized = append(normalized, line)
+	}
+	if inTable {
+		flush()
+	}
+
+	result := strings.Join(normalized, "\n")
+	if result == string(data) {
+		return false, nil
+	}
+
+	if err := os.WriteFile(path, []byte(result), 0644); err != nil {
+		return false, fmt.Errorf("failed to write pyproject.toml: %v", err)
+	}
+
+	return true, nil
+}
+
+// ResolveRunConfig checks the overrides against the keys and value types of
+// [tool.flwr.app.config] and returns the overrides converted to the types of
+// the defaults together with the effective run config
+func (p *PyProject) ResolveRunConfig(overrides map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(overrides))
+	effective := make(map[string]interface{}, len(p.Tool.Flwr.App.Config))
+	for key, value := range p.Tool.Flwr.App.Config {
+		effective[key] = value
+	}
+
+	for key, value := range overrides {
+		defaultValue, ok := p.Tool.Flwr.App.Config[key]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown run config key %q", key)
+		}
+
+		converted, err := convertRunConfigValue(defaultValue, value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid value for run config key %q: %v", key, err)
+		}
+
+		resolved[key] = converted
+		effective[key] = converted
+	}
+
+	return resolved, effective, nil
+}
+
+func convertRunConfigValue(defaultValue, value interface{}) (interface{}, error) {
+	switch defaultValue.(type) {
+	case int64:
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			return nil, fmt.Errorf("expected an integer")
+		}
+		return int64(number), nil
+	case float64:
+		number, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("expected a number")
+		}
+		return number, nil
+	case bool:
+		flag, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expected a boolean")
+		}
+		return flag, nil
+	case string:
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string")
+		}
+		return text, nil
+	default:
+		return nil, fmt.Errorf("only integer, float, boolean and string values can be overridden")
+	}
+}
+
+// FormatRunConfig renders run config values in the key=value form expected by
+// flwr run --run-config, with keys in sorted order. Integers must be int64 as
+// returned by ResolveRunConfig, float64 values are always rendered as floats.
+func FormatRunConfig(config map[string]interface{}) string {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		var value string
+		switch v := config[key].(type) {
+		case string:
+			value = strconv.Quote(v)
+		case float64:
+			value = strconv.FormatFloat(v, 'g', -1, 64)
+			// Keep floats from being read back as integers
+			if !strings.ContainsAny(value, ".eE") {
+				value += ".0"
+			}
+		default:
+			value = fmt.Sprintf("%v", v)
+		}
+		pairs[i] = fmt.Sprintf("%s=%s", key, value)
+	}
+
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeFederation(t *testing.T) {
+	tests := []struct {
+		name       string
+		federation string
+		table      string
+		changed    bool
+	}{
+		{name: "bare key", federation: "my-app", table: "[tool.flwr.federations.my-app]", changed: true},
+		{name: "quoted bare key", federation: "my-app", table: `[tool.flwr.federations."my-app"]`, changed: true},
+		{name: "quoted dotted key", federation: "my.app", table: `[tool.flwr.federations."my.app"]`, changed: true},
+		{name: "nested table is another federation", federation: "my.app", table: "[tool.flwr.federations.my.app]"},
+		{name: "other federation", federation: "my-app", table: "[tool.flwr.federations.other]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "pyproject.toml")
+			original := tt.table + "\naddress = \"remote:9093\"\ninsecure = true\n\n[tool.other]\nkey = 1\n"
+			mustWriteFile(t, path, original)
+
+			changed, err := NormalizeFederation(path, tt.federation, "127.0.0.1:9093", "/certs/ca.crt")
+			if err != nil {
+				t.Fatalf("NormalizeFederation() error = %v", err)
+			}
+			if changed != tt.changed {
+				t.Fatalf("NormalizeFederation() changed = %v, want %v", changed, tt.changed)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.changed {
+				if string(data) != original {
+					t.Fatalf("pyproject.toml was rewritten:\n%s", data)
+				}
+				return
+			}
+			for _, line := range []string{`address = "127.0.0.1:9093"`, `root-certificates = "/certs/ca.crt"`, "insecure = true", "key = 1"} {
+				if !strings.Contains(string(data), line) {
+					t.Fatalf("pyproject.toml misses %q:\n%s", line, data)
+				}
+			}
+
+			// The rewritten table must be the one Validate looks up
+			pyproject, err := ParsePyProject(data)
+			if err != nil {
+				t.Fatalf("ParsePyProject() error = %v", err)
+			}
+			federation, ok := pyproject.Tool.Flwr.Federations[tt.federation].(map[string]interface{})
+			if !ok || federation["address"] != "127.0.0.1:9093" {
+				t.Fatalf("federation %q = %v", tt.federation, pyproject.Tool.Flwr.Federations[tt.federation])
+			}
+		})
+	}
+}
+
+func TestTomlKey(t *testing.T) {
+	tests := map[string]string{
+		"my-app":  "my-app",
+		"my_app2": "my_app2",
+		"my.app":  `"my.app"`,
+		"my app":  `"my app"`,
+	}
+	for key, want := range tests {
+		if got := tomlKey(key); got != want {
+			t.Errorf("tomlKey(%q) = %s, want %s", key, got, want)
+		}
+	}
+}
@@ -8,18 +8,26 @@ import (
 	"path/filepath"
 	"runtime"
 	"sync"
-	"syscall"
 	"time"
 )
 
 type PythonEnv struct {
-	VenvPath     string
-	BinPath      string
-	Python       string
-	Pip          string
-	mu           sync.Mutex
-	SuperLinkCmd *exec.Cmd
-	FlwrExecCmd *exec.Cmd
+	VenvPath        string
+	BinPath         string
+	Python          string
+	Pip             string
+	mu              sync.Mutex
+	baseInterpreter string
+	baseVersion     string
+	wheelhouse      string
+	offline         bool
+	envLocks        map[string]*sync.Mutex
+	federations     map[string]*Federation
+	federationsMu   sync.Mutex
+	runner          Runner
+	events          FederationEvents
+	portRangeStart  int
+	maxFederations  int
 }
 
 var (
@@ -63,6 +71,10 @@ func GetSharedPythonEnv(baseDir string) (*PythonEnv, error) {
 			BinPath:  filepath.Join(venvPath, "bin"),
 			Python:   filepath.Join(venvPath, "bin", "python"),
 			Pip:      filepath.Join(venvPath, "bin", "pip"),
+
+			federations:    make(map[string]*Federation),
+			portRangeStart: 9100,
+			maxFederations: 1,
 		}
 
 		// Create virtual environment if it doesn't exist
@@ -73,11 +85,6 @@ func GetSharedPythonEnv(baseDir string) (*PythonEnv, error) {
 				return
 			}
 		}
-
-		if err := sharedEnv.InstallFlwr(); err != nil {
-			initErr = fmt.Errorf("failed to install Flower: %v", err)
-			return
-		}
 	})
 
 	if initErr != nil {
@@ -87,133 +94,163 @@ func GetSharedPythonEnv(baseDir string) (*PythonEnv, error) {
 	return sharedEnv, nil
 }
 
-// InstallExperimentDependencies installs packages in the shared environment using the experiments pyproject.toml
-func (env *PythonEnv) InstallExperimentDependencies(experimentDir string) error {
-	env.mu.Lock()
-	defer env.mu.Unlock()
+// InstallFlwr installs Flower into the shared environment, from the
+// wheelhouse when offline
+func (env *PythonEnv) InstallFlwr() error {
+	cmd := exec.Command(env.Pip, env.pipInstallArgs(flwrRequirement)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("pip install failed: %v\nOutput: %s", err, output)
+	}
+	return nil
+}
 
-	// Check if pyproject.toml exists
-	if _, err := os.Stat(filepath.Join(experimentDir, "pyproject.toml")); os.IsNotExist(err) {
-		return fmt.Errorf("pyproject.toml not found in %s", experimentDir)
+// RunFlwr submits the experiment to its own federation with flwr from the
+// experiment's environment and returns the started process. A non-empty
+// runConfig is passed on as --run-config. Its exit is published as a
+// federation event.
+func (env *PythonEnv) RunFlwr(experimentDir, experimentID, experimentName, runConfig string) (Process, error) {
+	federation, ok := env.GetFederation(experimentID)
+	if !ok {
+		return nil, fmt.Errorf("no SuperLink running for experiment %s", experimentID)
 	}
 
-	cmd := exec.Command(env.Pip, "install", "-e", ".")
-	cmd.Dir = experimentDir
-	cmd.Env = append(os.Environ(),
-		fmt.Sprintf("VIRTUAL_ENV=%s", env.VenvPath),
-		fmt.Sprintf("PATH=%s%c%s", env.BinPath, os.PathListSeparator, os.Getenv("PATH")),
-	)
+	federationConfig, err := federation.FederationConfig()
+	if err != nil {
+		return nil, err
+	}
 
-	output, err := cmd.CombinedOutput()
+	if err := os.MkdirAll(federation.LogDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create logs directory: %v", err)
+	}
+	timestamp := time.Now().Format("20060102150405") // Format: YYYYMMDDHHMMSS
+	logFile := filepath.Join(federation.LogDir, fmt.Sprintf("flwr_%s.log", timestamp))
+	federation.FlwrLog = logFile
+
+	process, err := env.federationRunner().SubmitRun(RunSpec{
+		ExperimentID:     experimentID,
+		Env:              federation.Env,
+		AppDir:           experimentDir,
+		Federation:       experimentName,
+		FederationConfig: federationConfig,
+		RunConfig:        runConfig,
+		LogFile:          logFile,
+	})
 	if err != nil {
-		return fmt.Errorf("pip install failed: %v\nOutput: %s", err, output)
+		return nil, fmt.Errorf("failed to start flwr: %v", err)
 	}
+	federation.Flwr = process
+	log.Printf("Started flwr for experiment %s as %s", experimentID, process.ID())
+	env.watch(experimentID, ProcessFlwr, process)
 
-	return nil
+	return process, nil
 }
 
-func (env *PythonEnv) InstallFlwr() error {
-	cmd := exec.Command(env.Pip, "install", "flwr==1.15.0")
-	return cmd.Run()
-}
+// StartSuperLink starts the SuperLink of a reserved federation from venv with
+// SSL and authentication. ServerApps started by the SuperLink run in the same
+// environment. The public keys of the nodes allowed to connect are written to
+// the federation's KeysFile by the keys callback before the SuperLink starts.
+// The SuperLink may not accept connections yet when StartSuperLink returns,
+// see WaitForSuperLink.
+func (env *PythonEnv) StartSuperLink(experimentID string, venv *VirtualEnv, writeKeys func(keysFile string) error) (*Federation, error) {
+	federation, ok := env.GetFederation(experimentID)
+	if !ok {
+		return nil, fmt.Errorf("no federation reserved for experiment %s", experimentID)
+	}
+	federation.Env = venv
 
-// RunFlwr executes the experiment using the flwr command
-func (env *PythonEnv) RunFlwr(experimentDir, experimentID, experimentName string) error {
-	timestamp := time.Now().Format("20060102150405") // Format: YYYYMMDDHHMMSS
-	logFileName := fmt.Sprintf("flwr_%s.log", timestamp)
-	logLocation := filepath.Join("uploads", experimentID, "logs")
-	logFile := filepath.Join(logLocation, logFileName)
+	if err := os.MkdirAll(filepath.Dir(federation.KeysFile), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create keys directory: %v", err)
+	}
 
-	if err := os.MkdirAll(logLocation, 0755); err != nil {
-		return fmt.Errorf("failed to create logs directory: %v", err)
+	if err := writeKeys(federation.KeysFile); err != nil {
+		return nil, err
 	}
 
-	flwrLogFile, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err := os.MkdirAll(federation.LogDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create logs directory: %v", err)
+	}
+	timestamp := time.Now().Format("20060102150405") // Format: YYYYMMDDHHMMSS
+	logFile := filepath.Join(federation.LogDir, fmt.Sprintf("superlink_%s.log", timestamp))
+	federation.SuperLinkLog = logFile
+
+	process, err := env.federationRunner().StartSuperLink(SuperLinkSpec{
+		ExperimentID:          experimentID,
+		Env:                   venv,
+		FleetAPIAddress:       fmt.Sprintf("0.0.0.0:%d", federation.FleetPort),
+		ExecAPIAddress:        fmt.Sprintf("0.0.0.0:%d", federation.ExecPort),
+		ServerAppIoAPIAddress: fmt.Sprintf("0.0.0.0:%d", federation.ServerAppIoPort),
+		CACertFile:            caCertFile,
+		CertFile:              serverCertFile,
+		KeyFile:               serverKeyFile,
+		KeysFile:              federation.KeysFile,
+		LogFile:               logFile,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to open flwr log file: %v", err)
+		return nil, fmt.Errorf("failed to start SuperLink: %v", err)
 	}
-	defer flwrLogFile.Close()
+	federation.SuperLink = process
+	log.Printf("Started SuperLink for experiment %s as %s (fleet %d, exec %d, serverappio %d)",
+		experimentID, process.ID(), federation.FleetPort, federation.ExecPort, federation.ServerAppIoPort)
+	federation.superLinkExit = env.watch(experimentID, ProcessSuperLink, process)
 
-	// Run the experiment using flwr
-	cmd := exec.Command(filepath.Join(env.BinPath, "flwr"), "run", ".", experimentName, "--stream")
-	cmd.Dir = experimentDir
-	cmd.Env = append(os.Environ(),
-		fmt.Sprintf("VIRTUAL_ENV=%s", env.VenvPath),
-		fmt.Sprintf("PATH=%s%c%s", env.BinPath, os.PathListSeparator, os.Getenv("PATH")),
-	)
-
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	cmd.Stdout = flwrLogFile
-	cmd.Stderr = flwrLogFile
+	return federation, nil
+}
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start flwr: %v", err)
+func (env *PythonEnv) federationRunner() Runner {
+	env.federationsMu.Lock()
+	defer env.federationsMu.Unlock()
+	if env.runner == nil {
+		env.runner = &LocalRunner{}
 	}
-	env.FlwrExecCmd = cmd
-	log.Printf("Started flwr with PID: %d", cmd.Process.Pid)
-
-	return nil
+	return env.runner
 }
 
-// InitializeSuperLink starts the SuperLink process with SSL and authentication
-func (env *PythonEnv) InitializeSuperLink() error {
-	timestamp := time.Now().Format("20060102150405") // Format: YYYYMMDDHHMMSS
-	logFileName := fmt.Sprintf("superlink_%s.log", timestamp)
-	logFile := filepath.Join("logs", logFileName)
+// StopFederation terminates the flwr and SuperLink processes of an experiment
+// and releases its ports
+func (env *PythonEnv) StopFederation(experimentID string) error {
+	federation, ok := env.GetFederation(experimentID)
+	if !ok {
+		return nil
+	}
+	defer env.releaseFederation(experimentID)
 
-	if err := os.MkdirAll("logs", 0755); err != nil {
-		return fmt.Errorf("failed to create logs directory: %v", err)
+	flwrErr := terminateProcess(federation.Flwr)
+	if err := terminateProcess(federation.SuperLink); err != nil {
+		return fmt.Errorf("failed to stop SuperLink: %v", err)
+	}
+	if flwrErr != nil {
+		return fmt.Errorf("failed to stop flwr: %v", flwrErr)
 	}
 
-	superLinkLogFile, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to open SuperLink log file: %v", err)
-	}
-	defer superLinkLogFile.Close()
-
-	// Start SuperLink with SSL and authentication
-	superLinkCmd := exec.Command(filepath.Join(env.BinPath, "flower-superlink"),
-		"--ssl-ca-certfile", "authentication/certificates/ca.crt",
-		"--ssl-certfile", "authentication/certificates/server.pem",
-		"--ssl-keyfile", "authentication/certificates/server.key",
-		"--auth-list-public-keys", "authentication/keys/client_public_keys.csv")
-	superLinkCmd.Env = append(os.Environ(),
-		fmt.Sprintf("VIRTUAL_ENV=%s", env.VenvPath),
-		fmt.Sprintf("PATH=%s%c%s", env.BinPath, os.PathListSeparator, os.Getenv("PATH")),
-	)
-	superLinkCmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	superLinkCmd.Stdout = superLinkLogFile
-	superLinkCmd.Stderr = superLinkLogFile
-
-	if err := superLinkCmd.Start(); err != nil {
-		return fmt.Errorf("failed to start SuperLink: %v", err)
-	}
-	env.SuperLinkCmd = superLinkCmd
-	log.Printf("Started SuperLink with PID: %d", superLinkCmd.Process.Pid)
+	if err := os.RemoveAll(filepath.Dir(federation.KeysFile)); err != nil {
+		log.Printf("Failed to remove keys of experiment %s: %v", experimentID, err)
+	}
 
 	return nil
 }
 
-func (env *PythonEnv) CleanupSuperLink() error {
-	if env.SuperLinkCmd != nil && env.SuperLinkCmd.Process != nil {
-		if pgid, err := syscall.Getpgid(env.SuperLinkCmd.Process.Pid); err == nil {
-			if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
-				return fmt.Errorf("failed to stop SuperLink: %v", err)
-			}
+// StopAllFederations terminates every running federation
+func (env *PythonEnv) StopAllFederations() error {
+	env.federationsMu.Lock()
+	experimentIDs := make([]string, 0, len(env.federations))
+	for experimentID := range env.federations {
+		experimentIDs = append(experimentIDs, experimentID)
+	}
+	env.federationsMu.Unlock()
+
+	var lastErr error
+	for _, experimentID := range experimentIDs {
+		if err := env.StopFederation(experimentID); err != nil {
+			log.Printf("Error stopping federation of experiment %s: %v", experimentID, err)
+			lastErr = err
 		}
-		env.SuperLinkCmd = nil
 	}
-	return nil
+	return lastErr
 }
 
-func (env *PythonEnv) CleanupFlwr() error {
-	if env.FlwrExecCmd != nil && env.FlwrExecCmd.Process != nil {
-		if pgid, err := syscall.Getpgid(env.FlwrExecCmd.Process.Pid); err == nil {
-			if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
-				return fmt.Errorf("failed to stop SuperExec: %v", err)
-			}
-		}
-		env.FlwrExecCmd = nil
+func terminateProcess(process Process) error {
+	if process == nil {
+		return nil
 	}
-	return nil
+	return process.Terminate()
 }
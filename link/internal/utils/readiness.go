@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// readinessPollInterval is the pause between two rounds of probing a SuperLink
+const readinessPollInterval = 250 * time.Millisecond
+
+// WaitForSuperLink blocks until the Fleet and Exec API of an experiment's
+// SuperLink accept TLS connections verified against the link's CA and then
+// publishes FederationEventReady. It fails when the SuperLink exits or is not
+// ready within timeout; the caller is expected to stop the federation then.
+func (env *PythonEnv) WaitForSuperLink(experimentID string, timeout time.Duration) error {
+	federation, ok := env.GetFederation(experimentID)
+	if !ok || federation.SuperLink == nil {
+		return fmt.Errorf("no SuperLink running for experiment %s", experimentID)
+	}
+
+	tlsConfig, err := superLinkTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	apis := []struct {
+		name    string
+		address string
+	}{
+		{"Fleet API", federation.FleetAddress()},
+		{"Exec API", federation.ExecAddress()},
+	}
+
+	deadline := time.Now().Add(timeout)
+	for _, api := range apis {
+		for {
+			err := probeTLS(api.address, tlsConfig, time.Until(deadline))
+			if err == nil {
+				break
+			}
+
+			// Retrying does not help against a certificate nodes would reject too
+			var certErr *tls.CertificateVerificationError
+			if errors.As(err, &certErr) {
+				return fmt.Errorf("SuperLink %s on %s serves an invalid certificate: %v", api.name, api.address, err)
+			}
+
+			if exitCode, exited := federation.SuperLinkExited(); exited {
+				return fmt.Errorf("SuperLink exited with code %d before its %s was ready, see %s", exitCode, api.name, federation.SuperLinkLog)
+			}
+
+			if time.Now().Add(readinessPollInterval).After(deadline) {
+				return fmt.Errorf("SuperLink %s on %s did not accept TLS connections within %s: %v", api.name, api.address, timeout, err)
+			}
+			time.Sleep(readinessPollInterval)
+		}
+	}
+
+	env.events.publish(FederationEvent{Type: FederationEventReady, ExperimentID: experimentID, Kind: ProcessSuperLink, Process: federation.SuperLink})
+	return nil
+}
+
+// superLinkTLSConfig trusts the link's CA only, which issued the certificate
+// every SuperLink serves
+func superLinkTLSConfig() (*tls.Config, error) {
+	caCert, err := os.ReadFile(caCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("no certificate found in %s", caCertFile)
+	}
+
+	return &tls.Config{
+		RootCAs:    rootCAs,
+		NextProtos: []string{"h2"}, // gRPC
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// probeTLS completes a TLS handshake with address within timeout
+func probeTLS(address string, tlsConfig *tls.Config, timeout time.Duration) error {
+	if timeout <= 0 {
+		return fmt.Errorf("timed out")
+	}
+	if timeout > 2*time.Second {
+		timeout = 2 * time.Second
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, tlsConfig)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
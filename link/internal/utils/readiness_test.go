@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitForSuperLink(t *testing.T) {
+	tests := []struct {
+		name      string
+		trusted   bool
+		terminate bool
+		start     bool
+		wantErr   string
+	}{
+		{name: "ready", trusted: true, start: true},
+		{name: "not started", trusted: true, wantErr: "no SuperLink running"},
+		{name: "exited", trusted: true, start: true, terminate: true, wantErr: "exited with code -1"},
+		{name: "untrusted certificate", start: true, wantErr: "invalid certificate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testCertificates(t, tt.trusted)
+			env := newFakeEnv(t, &FakeRunner{RunDuration: time.Hour})
+			events := recordEvents(env)
+
+			if tt.start {
+				federation := startFakeSuperLink(t, env, "1")
+				if tt.terminate {
+					federation.SuperLink.Terminate()
+					events.waitFor(t, FederationEventExited, ProcessSuperLink)
+				}
+			}
+
+			err := env.WaitForSuperLink("1", 3*time.Second)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("WaitForSuperLink() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WaitForSuperLink() error = %v", err)
+			}
+			events.waitFor(t, FederationEventReady, ProcessSuperLink)
+		})
+	}
+}
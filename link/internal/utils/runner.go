@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Process is a SuperLink or flwr run started by a Runner
+type Process interface {
+	// ID identifies the process in logs, such as its PID or a container ID
+	ID() string
+	// Wait blocks until the process exits and returns its exit code. It is
+	// called once, by the PythonEnv that started the process.
+	Wait() (int, error)
+	// Terminate asks the process and everything it started to stop
+	Terminate() error
+}
+
+// SuperLinkSpec describes the SuperLink of one federation. Addresses are the
+// ones the SuperLink listens on and paths are relative to the working
+// directory of the link, so a container based runner can publish the ports
+// and mount the files.
+type SuperLinkSpec struct {
+	ExperimentID          string
+	Env                   *VirtualEnv
+	FleetAPIAddress       string
+	ExecAPIAddress        string
+	ServerAppIoAPIAddress string
+	CACertFile            string
+	CertFile              string
+	KeyFile               string
+	KeysFile              string
+	LogFile               string
+}
+
+// RunSpec describes a run submitted to a federation's Exec API
+type RunSpec struct {
+	ExperimentID     string
+	Env              *VirtualEnv
+	AppDir           string
+	Federation       string
+	FederationConfig string
+	RunConfig        string
+	LogFile          string
+}
+
+// Runner starts the SuperLink of a federation and submits runs to it
+type Runner interface {
+	StartSuperLink(spec SuperLinkSpec) (Process, error)
+	SubmitRun(spec RunSpec) (Process, error)
+}
+
+// NewRunner returns the runner configured by name: "local" starts Flower's
+// executables as local processes and "fake" simulates them in process
+func NewRunner(name string) (Runner, error) {
+	switch name {
+	case "", "local":
+		return &LocalRunner{}, nil
+	case "fake":
+		return &FakeRunner{RunDuration: 5 * time.Second}, nil
+	}
+	return nil, fmt.Errorf("unknown federation runner %q", name)
+}
+
+type ProcessKind string
+
+const (
+	ProcessSuperLink ProcessKind = "superlink"
+	ProcessFlwr      ProcessKind = "flwr"
+)
+
+type FederationEventType string
+
+const (
+	// FederationEventStarted is published once a process was started
+	FederationEventStarted FederationEventType = "started"
+	// FederationEventReady is published once a SuperLink accepts TLS
+	// connections on its Fleet and Exec API
+	FederationEventReady FederationEventType = "ready"
+	// FederationEventExited is published with the exit code once a process exited
+	FederationEventExited FederationEventType = "exited"
+)
+
+// FederationEvent reports a change in the lifecycle of a federation process
+type FederationEvent struct {
+	Type         FederationEventType
+	ExperimentID string
+	Kind         ProcessKind
+	Process      Process
+	ExitCode     int
+	Err          error
+	Time         time.Time
+}
+
+// FederationEvents delivers federation events to subscribers. Every
+// subscriber is called in its own goroutine so a slow one never holds up a
+// process.
+type FederationEvents struct {
+	mu          sync.Mutex
+	subscribers map[int]func(FederationEvent)
+	next        int
+}
+
+// Subscribe calls fn for every future event until the returned function is called
+func (e *FederationEvents) Subscribe(fn func(FederationEvent)) func() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.subscribers == nil {
+		e.subscribers = make(map[int]func(FederationEvent))
+	}
+	id := e.next
+	e.next++
+	e.subscribers[id] = fn
+
+	return func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.subscribers, id)
+	}
+}
+
+func (e *FederationEvents) publish(event FederationEvent) {
+	event.Time = time.Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, fn := range e.subscribers {
+		go fn(event)
+	}
+}
+
+// ConfigureRunner sets the runner starting SuperLinks and runs
+func (env *PythonEnv) ConfigureRunner(runner Runner) {
+	env.federationsMu.Lock()
+	defer env.federationsMu.Unlock()
+	env.runner = runner
+}
+
+// Events returns the lifecycle events of the federation processes
+func (env *PythonEnv) Events() *FederationEvents {
+	return &env.events
+}
+
+// processExit is closed once a watched process exited
+type processExit struct {
+	done chan struct{}
+	code int
+}
+
+func (e *processExit) exited() (int, bool) {
+	select {
+	case <-e.done:
+		return e.code, true
+	default:
+		return 0, false
+	}
+}
+
+// watch publishes the start of a federation process and its exit once it is
+// over
+func (env *PythonEnv) watch(experimentID string, kind ProcessKind, process Process) *processExit {
+	env.events.publish(FederationEvent{Type: FederationEventStarted, ExperimentID: experimentID, Kind: kind, Process: process})
+
+	exit := &processExit{done: make(chan struct{})}
+	go func() {
+		exitCode, err := process.Wait()
+		log.Printf("%s %s of experiment %s exited with code %d", kind, process.ID(), experimentID, exitCode)
+		exit.code = exitCode
+		close(exit.done)
+		env.events.publish(FederationEvent{Type: FederationEventExited, ExperimentID: experimentID, Kind: kind, Process: process, ExitCode: exitCode, Err: err})
+	}()
+	return exit
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FakeRunner simulates federations in process without starting Python, so
+// the experiment lifecycle can be exercised without Flower installed.
+// SuperLinks accept TLS connections on their Fleet and Exec API until they are
+// terminated and runs exit with ExitCode after RunDuration.
+type FakeRunner struct {
+	RunDuration time.Duration
+	ExitCode    int
+	next        atomic.Int64
+}
+
+func (r *FakeRunner) StartSuperLink(spec SuperLinkSpec) (Process, error) {
+	cert, err := tls.LoadX509KeyPair(spec.CertFile, spec.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SuperLink certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"h2"}}
+
+	process := r.newProcess("superlink")
+	for _, address := range []string{spec.FleetAPIAddress, spec.ExecAPIAddress} {
+		listener, err := tls.Listen("tcp", address, tlsConfig)
+		if err != nil {
+			process.exit(1)
+			return nil, err
+		}
+		go serveFake(listener, process.done)
+	}
+
+	if err := appendLog(spec.LogFile, "fake SuperLink %s listening on %s (Fleet API) and %s (Exec API)\n",
+		process.id, spec.FleetAPIAddress, spec.ExecAPIAddress); err != nil {
+		process.exit(1)
+		return nil, err
+	}
+	return process, nil
+}
+
+// serveFake completes the TLS handshake of every connection and closes it,
+// until done is closed
+func serveFake(listener net.Listener, done <-chan struct{}) {
+	go func() {
+		<-done
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			conn.(*tls.Conn).Handshake()
+		}()
+	}
+}
+
+func (r *FakeRunner) SubmitRun(spec RunSpec) (Process, error) {
+	process := r.newProcess("flwr")
+	if err := appendLog(spec.LogFile, "fake flwr run %s of %s started\n", process.id, spec.AppDir); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-time.After(r.RunDuration):
+			appendLog(spec.LogFile, "fake flwr run %s finished with exit code %d\n", process.id, r.ExitCode)
+			process.exit(r.ExitCode)
+		case <-process.done:
+		}
+	}()
+	return process, nil
+}
+
+func (r *FakeRunner) newProcess(kind string) *fakeProcess {
+	return &fakeProcess{
+		id:   fmt.Sprintf("fake-%s-%d", kind, r.next.Add(1)),
+		done: make(chan struct{}),
+	}
+}
+
+func appendLog(path, format string, args ...interface{}) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, format, args...)
+	return err
+}
+
+type fakeProcess struct {
+	id       string
+	done     chan struct{}
+	once     sync.Once
+	exitCode int
+}
+
+func (p *fakeProcess) ID() string {
+	return p.id
+}
+
+func (p *fakeProcess) Wait() (int, error) {
+	<-p.done
+	return p.exitCode, nil
+}
+
+// Terminate stops the process like a signal would, with exit code -1
+func (p *fakeProcess) Terminate() error {
+	p.exit(-1)
+	return nil
+}
+
+func (p *fakeProcess) exit(code int) {
+	p.once.Do(func() {
+		p.exitCode = code
+		close(p.done)
+	})
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCertificates writes a CA and a SuperLink certificate for 127.0.0.1 to
+// the relative certificate paths below a temporary working directory. With
+// trusted unset the SuperLink certificate is issued by a different CA.
+func testCertificates(t *testing.T, trusted bool) {
+	t.Helper()
+
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	if err := os.MkdirAll(filepath.Dir(caCertFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	caCert, caKey := newTestCA(t)
+	issuer, issuerKey := caCert, caKey
+	if !trusted {
+		issuer, issuerKey = newTestCA(t)
+	}
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "superlink"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, template, issuer, &serverKey.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKeyDER, err := x509.MarshalECPrivateKey(serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writePEM(t, caCertFile, "CERTIFICATE", caCert.Raw)
+	writePEM(t, serverCertFile, "CERTIFICATE", serverDER)
+	writePEM(t, serverKeyFile, "EC PRIVATE KEY", serverKeyDER)
+}
+
+func newTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// freePortRange returns the start of three consecutive ports that were free
+func freePortRange(t *testing.T) int {
+	t.Helper()
+	for i := 0; i < 20; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+		if port+2 < 65536 && portsAvailable(port, port+1, port+2) {
+			return port
+		}
+	}
+	t.Fatal("no free ports found")
+	return 0
+}
+
+// eventRecorder collects the federation events of a PythonEnv
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []FederationEvent
+	notify chan struct{}
+}
+
+func recordEvents(env *PythonEnv) *eventRecorder {
+	recorder := &eventRecorder{notify: make(chan struct{}, 64)}
+	env.Events().Subscribe(func(event FederationEvent) {
+		recorder.mu.Lock()
+		recorder.events = append(recorder.events, event)
+		recorder.mu.Unlock()
+		recorder.notify <- struct{}{}
+	})
+	return recorder
+}
+
+// waitFor blocks until an event of the given type and kind was published
+func (r *eventRecorder) waitFor(t *testing.T, eventType FederationEventType, kind ProcessKind) FederationEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		r.mu.Lock()
+		for _, event := range r.events {
+			if event.Type == eventType && event.Kind == kind {
+				r.mu.Unlock()
+				return event
+			}
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-r.notify:
+		case <-timeout:
+			t.Fatalf("no %s event of the %s published", eventType, kind)
+		}
+	}
+}
+
+func newFakeEnv(t *testing.T, runner *FakeRunner) *PythonEnv {
+	t.Helper()
+	env := &PythonEnv{federations: make(map[string]*Federation)}
+	env.ConfigureFederations(freePortRange(t), 1)
+	env.ConfigureRunner(runner)
+	t.Cleanup(func() { env.StopAllFederations() })
+	return env
+}
+
+func startFakeSuperLink(t *testing.T, env *PythonEnv, experimentID string) *Federation {
+	t.Helper()
+	if _, err := env.ReserveFederation(experimentID, "logs"); err != nil {
+		t.Fatalf("ReserveFederation() error = %v", err)
+	}
+	federation, err := env.StartSuperLink(experimentID, nil, func(keysFile string) error {
+		return os.WriteFile(keysFile, nil, 0644)
+	})
+	if err != nil {
+		t.Fatalf("StartSuperLink() error = %v", err)
+	}
+	return federation
+}
+
+func TestFakeFederationLifecycle(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode int
+	}{
+		{name: "run succeeds", exitCode: 0},
+		{name: "run fails", exitCode: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testCertificates(t, true)
+			env := newFakeEnv(t, &FakeRunner{RunDuration: 50 * time.Millisecond, ExitCode: tt.exitCode})
+			events := recordEvents(env)
+
+			federation := startFakeSuperLink(t, env, "1")
+			events.waitFor(t, FederationEventStarted, ProcessSuperLink)
+			if env.HasFreeFederationSlot() {
+				t.Fatal("the running federation does not take its slot")
+			}
+
+			if _, err := env.RunFlwr("app", "1", "experiment-1", ""); err != nil {
+				t.Fatalf("RunFlwr() error = %v", err)
+			}
+			events.waitFor(t, FederationEventStarted, ProcessFlwr)
+			if exited := events.waitFor(t, FederationEventExited, ProcessFlwr); exited.ExitCode != tt.exitCode {
+				t.Fatalf("flwr exited with code %d, want %d", exited.ExitCode, tt.exitCode)
+			}
+			select {
+			case <-federation.SuperLink.(*fakeProcess).done:
+				t.Fatal("the SuperLink exited with the run")
+			default:
+			}
+
+			if err := env.StopFederation("1"); err != nil {
+				t.Fatalf("StopFederation() error = %v", err)
+			}
+			if exited := events.waitFor(t, FederationEventExited, ProcessSuperLink); exited.ExitCode != -1 {
+				t.Fatalf("SuperLink exited with code %d, want -1", exited.ExitCode)
+			}
+			if !env.HasFreeFederationSlot() {
+				t.Fatal("StopFederation() did not release the slot")
+			}
+		})
+	}
+}
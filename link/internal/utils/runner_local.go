@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// LocalRunner starts flower-superlink and flwr from the experiment's virtual
+// environment as local processes, each in its own process group
+type LocalRunner struct{}
+
+func (r *LocalRunner) StartSuperLink(spec SuperLinkSpec) (Process, error) {
+	cmd := exec.Command(filepath.Join(spec.Env.BinPath, "flower-superlink"),
+		"--ssl-ca-certfile", spec.CACertFile,
+		"--ssl-certfile", spec.CertFile,
+		"--ssl-keyfile", spec.KeyFile,
+		"--auth-list-public-keys", spec.KeysFile,
+		"--fleet-api-address", spec.FleetAPIAddress,
+		"--exec-api-address", spec.ExecAPIAddress,
+		"--serverappio-api-address", spec.ServerAppIoAPIAddress)
+	cmd.Env = spec.Env.Environ()
+
+	return startLocalProcess(cmd, spec.LogFile)
+}
+
+func (r *LocalRunner) SubmitRun(spec RunSpec) (Process, error) {
+	args := []string{"run", ".", spec.Federation, "--stream", "--federation-config", spec.FederationConfig}
+	if spec.RunConfig != "" {
+		args = append(args, "--run-config", spec.RunConfig)
+	}
+	cmd := exec.Command(filepath.Join(spec.Env.BinPath, "flwr"), args...)
+	cmd.Dir = spec.AppDir
+	cmd.Env = spec.Env.Environ()
+
+	return startLocalProcess(cmd, spec.LogFile)
+}
+
+func startLocalProcess(cmd *exec.Cmd, logFile string) (Process, error) {
+	output, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer output.Close()
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &localProcess{cmd: cmd}, nil
+}
+
+type localProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p *localProcess) ID() string {
+	return strconv.Itoa(p.cmd.Process.Pid)
+}
+
+// Wait reaps the process, so it does not linger as a zombie once it exits.
+// Processes killed by a signal report -1.
+func (p *localProcess) Wait() (int, error) {
+	err := p.cmd.Wait()
+	return p.cmd.ProcessState.ExitCode(), err
+}
+
+func (p *localProcess) Terminate() error {
+	if pgid, err := syscall.Getpgid(p.cmd.Process.Pid); err == nil {
+		if err := syscall.Kill(-pgid, syscall.SIGTERM); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ManifestSigner signs experiment manifests with the link's server key so
+// nodes can check that a package came from the link unmodified
+type ManifestSigner struct {
+	signer    crypto.Signer
+	hash      crypto.Hash
+	algorithm string
+}
+
+// LoadManifestSigner reads an ECDSA or Ed25519 private key in OpenSSH or PEM
+// format, such as authentication/keys/server_credentials
+func LoadManifestSigner(path string) (*ManifestSigner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server key: %v", err)
+	}
+
+	key, err := ssh.ParseRawPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse server key: %v", err)
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return &ManifestSigner{signer: k, hash: crypto.SHA256, algorithm: "ecdsa-p256-sha256"}, nil
+		case elliptic.P384():
+			return &ManifestSigner{signer: k, hash: crypto.SHA384, algorithm: "ecdsa-p384-sha384"}, nil
+		case elliptic.P521():
+			return &ManifestSigner{signer: k, hash: crypto.SHA512, algorithm: "ecdsa-p521-sha512"}, nil
+		}
+		return nil, fmt.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+	case *ed25519.PrivateKey:
+		return &ManifestSigner{signer: *k, algorithm: "ed25519"}, nil
+	case ed25519.PrivateKey:
+		return &ManifestSigner{signer: k, algorithm: "ed25519"}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported server key type %T", key)
+}
+
+// ManifestSignatureMessage returns the bytes that are signed for a revision.
+// Binding the experiment and revision keeps a signature from being replayed
+// for another package.
+func ManifestSignatureMessage(experimentID uint, revision int, manifestHash string) []byte {
+	return []byte(fmt.Sprintf("icfl-manifest\nexperiment:%d\nrevision:%d\nsha256:%s\n", experimentID, revision, manifestHash))
+}
+
+// SignManifest returns the base64 encoded signature of a revision's manifest.
+// ECDSA signatures are ASN.1 DER encoded.
+func (s *ManifestSigner) SignManifest(experimentID uint, revision int, manifestHash string) (string, error) {
+	message := ManifestSignatureMessage(experimentID, revision, manifestHash)
+
+	var digest []byte
+	switch s.hash {
+	case crypto.SHA256:
+		sum := sha256.Sum256(message)
+		digest = sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(message)
+		digest = sum[:]
+	case crypto.SHA512:
+		sum := sha512.Sum512(message)
+		digest = sum[:]
+	default:
+		// Ed25519 signs the message itself
+		digest = message
+	}
+
+	signature, err := s.signer.Sign(rand.Reader, digest, s.hash)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign manifest: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// Algorithm names the key and hash used for signatures
+func (s *ManifestSigner) Algorithm() string {
+	return s.algorithm
+}
+
+// PublicKeyPEM returns the verification key as a PEM encoded PKIX public key
+func (s *ManifestSigner) PublicKeyPEM() (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(s.signer.Public())
+	if err != nil {
+		return "", fmt.Errorf("failed to encode public key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// PublicKeySSH returns the verification key in authorized_keys format with
+// its SHA-256 fingerprint
+func (s *ManifestSigner) PublicKeySSH() (string, string, error) {
+	publicKey, err := ssh.NewPublicKey(s.signer.Public())
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode public key: %v", err)
+	}
+	return string(ssh.MarshalAuthorizedKey(publicKey)), ssh.FingerprintSHA256(publicKey), nil
+}
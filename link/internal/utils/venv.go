@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// flwrRequirement is installed into every experiment environment next to the
+// experiment's own dependencies
+const flwrRequirement = "flwr==1.15.0"
+
+// envMarkerFile is written into an experiment environment once it is fully
+// installed. Its modification time records when the environment was last used.
+const envMarkerFile = ".icfl-env.json"
+
+// VirtualEnv is an isolated Python environment holding the dependencies of
+// one or more experiment revisions
+type VirtualEnv struct {
+	Key     string
+	Path    string
+	BinPath string
+	Python  string
+	Pip     string
+}
+
+func newVirtualEnv(key, path string) *VirtualEnv {
+	return &VirtualEnv{
+		Key:     key,
+		Path:    path,
+		BinPath: filepath.Join(path, "bin"),
+		Python:  filepath.Join(path, "bin", "python"),
+		Pip:     filepath.Join(path, "bin", "pip"),
+	}
+}
+
+// Environ returns the process environment with the virtual environment activated
+func (v *VirtualEnv) Environ() []string {
+	return append(os.Environ(),
+		fmt.Sprintf("VIRTUAL_ENV=%s", v.Path),
+		fmt.Sprintf("PATH=%s%c%s", v.BinPath, os.PathListSeparator, os.Getenv("PATH")),
+	)
+}
+
+type envMarker struct {
+	Key          string    `json:"key"`
+	Python       string    `json:"python"`
+	Requirements []string  `json:"requirements"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ConfigureEnvironments sets the interpreter experiment environments are
+// created from
+func (env *PythonEnv) ConfigureEnvironments(baseInterpreter string) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.baseInterpreter = baseInterpreter
+	env.baseVersion = ""
+}
+
+func (env *PythonEnv) envsDir() string {
+	return filepath.Join(filepath.Dir(env.VenvPath), "envs")
+}
+
+// interpreterVersion returns the version of the base interpreter, which is
+// part of every environment key
+func (env *PythonEnv) interpreterVersion() (string, string, error) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	if env.baseInterpreter == "" {
+		env.baseInterpreter = "python3"
+	}
+	if env.baseVersion == "" {
+		output, err := exec.Command(env.baseInterpreter, "--version").CombinedOutput()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to run %s: %v", env.baseInterpreter, err)
+		}
+		env.baseVersion = strings.TrimSpace(string(output))
+	}
+	return env.baseInterpreter, env.baseVersion, nil
+}
+
+// experimentRequirements returns the sorted requirements of the app in
+// experimentDir, including Flower itself
+func experimentRequirements(experimentDir string) ([]string, error) {
+	pyproject, err := LoadPyProject(filepath.Join(experimentDir, "pyproject.toml"))
+	if err != nil {
+		return nil, err
+	}
+
+	requirements := []string{flwrRequirement}
+	for _, dependency := range pyproject.Project.Dependencies {
+		if dependency = strings.TrimSpace(dependency); dependency != "" {
+			requirements = append(requirements, dependency)
+		}
+	}
+	sort.Strings(requirements)
+	return requirements, nil
+}
+
+// ExperimentEnvKey returns the content hash identifying the environment of the
+// app in experimentDir. Revisions with the same interpreter and dependencies
+// share an environment.
+func (env *PythonEnv) ExperimentEnvKey(experimentDir string) (string, error) {
+	_, version, err := env.interpreterVersion()
+	if err != nil {
+		return "", err
+	}
+	requirements, err := experimentRequirements(experimentDir)
+	if err != nil {
+		return "", err
+	}
+	return envKey(version, requirements), nil
+}
+
+func envKey(version string, requirements []string) string {
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%s\n", version)
+	for _, requirement := range requirements {
+		fmt.Fprintf(hasher, "%s\n", requirement)
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil))[:16]
+}
+
+// lockEnv returns the lock serializing the creation and removal of one environment
+func (env *PythonEnv) lockEnv(key string) *sync.Mutex {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.envLocks == nil {
+		env.envLocks = make(map[string]*sync.Mutex)
+	}
+	lock, ok := env.envLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		env.envLocks[key] = lock
+	}
+	return lock
+}
+
+// EnsureExperimentEnv returns the environment for the app in experimentDir,
+// creating it from the base interpreter and installing Flower and the
+// dependencies of its pyproject.toml when no environment with the same
+// content hash exists yet. Packages come from the wheelhouse when offline.
+func (env *PythonEnv) EnsureExperimentEnv(experimentDir string) (*VirtualEnv, error) {
+	interpreter, version, err := env.interpreterVersion()
+	if err != nil {
+		return nil, err
+	}
+	requirements, err := experimentRequirements(experimentDir)
+	if err != nil {
+		return nil, err
+	}
+
+	key := envKey(version, requirements)
+	venv := newVirtualEnv(key, filepath.Join(env.envsDir(), key))
+
+	lock := env.lockEnv(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	markerPath := filepath.Join(venv.Path, envMarkerFile)
+	if _, err := os.Stat(markerPath); err == nil {
+		now := time.Now()
+		if err := os.Chtimes(markerPath, now, now); err != nil {
+			log.Printf("Failed to record use of Python environment %s: %v", key, err)
+		}
+		return venv, nil
+	}
+
+	// Leftovers of an interrupted installation are never reused
+	if err := os.RemoveAll(venv.Path); err != nil {
+		return nil, fmt.Errorf("failed to remove incomplete environment: %v", err)
+	}
+	if err := os.MkdirAll(env.envsDir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create environments directory: %v", err)
+	}
+
+	log.Printf("Creating Python environment %s for %s", key, experimentDir)
+	if output, err := exec.Command(interpreter, "-m", "venv", venv.Path).CombinedOutput(); err != nil {
+		os.RemoveAll(venv.Path)
+		return nil, fmt.Errorf("failed to create virtual environment: %v\nOutput: %s", err, output)
+	}
+
+	cmd := exec.Command(venv.Pip, env.pipInstallArgs(requirements...)...)
+	cmd.Dir = experimentDir
+	cmd.Env = venv.Environ()
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(venv.Path)
+		return nil, fmt.Errorf("pip install failed: %v\nOutput: %s", err, output)
+	}
+
+	marker, err := json.MarshalIndent(envMarker{
+		Key:          key,
+		Python:       version,
+		Requirements: requirements,
+		CreatedAt:    time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(markerPath, marker, 0644); err != nil {
+		os.RemoveAll(venv.Path)
+		return nil, fmt.Errorf("failed to write environment marker: %v", err)
+	}
+
+	return venv, nil
+}
+
+// CollectExperimentEnvs removes the experiment environments that are not in
+// inUse and were last used longer than retention ago, along with incomplete
+// ones left by failed installations. It returns the keys of the removed
+// environments. Environments that are being installed are skipped.
+func (env *PythonEnv) CollectExperimentEnvs(inUse map[string]bool, retention time.Duration) ([]string, error) {
+	entries, err := os.ReadDir(env.envsDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-retention)
+	var removed []string
+	for _, entry := range entries {
+		key := entry.Name()
+		if !entry.IsDir() || inUse[key] {
+			continue
+		}
+
+		lock := env.lockEnv(key)
+		if !lock.TryLock() {
+			continue
+		}
+
+		path := filepath.Join(env.envsDir(), key)
+		info, err := os.Stat(filepath.Join(path, envMarkerFile))
+		if err == nil && info.ModTime().After(cutoff) {
+			lock.Unlock()
+			continue
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("Failed to remove Python environment %s: %v", key, err)
+		} else {
+			removed = append(removed, key)
+		}
+		lock.Unlock()
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WheelFile is a wheel stored in the wheelhouse
+type WheelFile struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// RequirementStatus tells whether a requirement of an experiment, together
+// with everything it depends on, can be installed from the wheelhouse alone
+type RequirementStatus struct {
+	Requirement string `json:"requirement"`
+	Satisfied   bool   `json:"satisfied"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ConfigureWheelhouse sets the directory of locally provided wheels. pip looks
+// there before the package index, and only there when offline is set.
+func (env *PythonEnv) ConfigureWheelhouse(wheelhouse string, offline bool) error {
+	absPath, err := filepath.Abs(wheelhouse)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	if err := os.MkdirAll(absPath, 0755); err != nil {
+		return fmt.Errorf("failed to create wheelhouse directory: %v", err)
+	}
+
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.wheelhouse = absPath
+	env.offline = offline
+	return nil
+}
+
+// Offline reports whether pip is restricted to the wheelhouse
+func (env *PythonEnv) Offline() bool {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	return env.offline
+}
+
+// pipSourceArgs returns the pip options selecting where packages come from
+func (env *PythonEnv) pipSourceArgs() []string {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+
+	if env.wheelhouse == "" {
+		return nil
+	}
+	if env.offline {
+		return []string{"--no-index", "--find-links", env.wheelhouse}
+	}
+	return []string{"--find-links", env.wheelhouse}
+}
+
+func (env *PythonEnv) pipInstallArgs(requirements ...string) []string {
+	args := append([]string{"install"}, env.pipSourceArgs()...)
+	return append(args, requirements...)
+}
+
+// IsWheelFileName reports whether name is a plain wheel file name of the form
+// {distribution}-{version}(-{build})?-{python}-{abi}-{platform}.whl
+func IsWheelFileName(name string) bool {
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".whl") {
+		return false
+	}
+	parts := strings.Split(strings.TrimSuffix(name, ".whl"), "-")
+	if len(parts) != 5 && len(parts) != 6 {
+		return false
+	}
+	for _, part := range parts {
+		if part == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// SaveWheel stores a wheel in the wheelhouse, replacing one with the same name
+func (env *PythonEnv) SaveWheel(name string, src io.Reader) error {
+	if !IsWheelFileName(name) {
+		return fmt.Errorf("%q is not a wheel file name", name)
+	}
+
+	wheelhouse := env.wheelhouseDir()
+	tempFile, err := os.CreateTemp(wheelhouse, ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	if _, err := io.Copy(tempFile, src); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tempFile.Name(), 0644); err != nil {
+		return err
+	}
+
+	// pip never sees a partially written wheel
+	return os.Rename(tempFile.Name(), filepath.Join(wheelhouse, name))
+}
+
+// ListWheels returns the wheels in the wheelhouse sorted by name
+func (env *PythonEnv) ListWheels() ([]WheelFile, error) {
+	entries, err := os.ReadDir(env.wheelhouseDir())
+	if err != nil {
+		return nil, err
+	}
+
+	wheels := []WheelFile{}
+	for _, entry := range entries {
+		if entry.IsDir() || !IsWheelFileName(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		wheels = append(wheels, WheelFile{Name: entry.Name(), Size: info.Size(), ModifiedAt: info.ModTime()})
+	}
+	sort.Slice(wheels, func(i, j int) bool { return wheels[i].Name < wheels[j].Name })
+	return wheels, nil
+}
+
+// RemoveWheel deletes a wheel from the wheelhouse
+func (env *PythonEnv) RemoveWheel(name string) error {
+	if !IsWheelFileName(name) {
+		return fmt.Errorf("%q is not a wheel file name: %w", name, os.ErrNotExist)
+	}
+	return os.Remove(filepath.Join(env.wheelhouseDir(), name))
+}
+
+func (env *PythonEnv) wheelhouseDir() string {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	return env.wheelhouse
+}
+
+// CheckWheelhouse resolves every requirement of the app in experimentDir,
+// including Flower, against the wheelhouse without using the package index.
+// Requirements are resolved one at a time so that every missing one is
+// reported, not only the first.
+func (env *PythonEnv) CheckWheelhouse(experimentDir string) ([]RequirementStatus, error) {
+	requirements, err := experimentRequirements(experimentDir)
+	if err != nil {
+		return nil, err
+	}
+
+	wheelhouse := env.wheelhouseDir()
+	if wheelhouse == "" {
+		return nil, fmt.Errorf("no wheelhouse is configured")
+	}
+
+	downloadDir, err := os.MkdirTemp("", "icfl-wheelhouse-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(downloadDir)
+
+	statuses := make([]RequirementStatus, len(requirements))
+	for i, requirement := range requirements {
+		statuses[i] = RequirementStatus{Requirement: requirement, Satisfied: true}
+
+		// pip download resolves the dependencies for this interpreter and
+		// platform the same way an install would
+		cmd := exec.Command(env.Pip, "download", "--no-index", "--find-links", wheelhouse,
+			"--dest", downloadDir, "--quiet", requirement)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			statuses[i].Satisfied = false
+			statuses[i].Error = pipErrors(string(output), err)
+		}
+	}
+
+	return statuses, nil
+}
+
+// pipErrors keeps the ERROR lines of pip's output
+func pipErrors(output string, err error) string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "ERROR:") {
+			lines = append(lines, strings.TrimSpace(strings.TrimPrefix(line, "ERROR:")))
+		}
+	}
+	if len(lines) == 0 {
+		return err.Error()
+	}
+	return strings.Join(lines, "\n")
+}